@@ -13,6 +13,7 @@ import (
 	"github.com/bsm/openmetrics"
 	"github.com/bsm/openmetrics/omhttp"
 	"github.com/yvesf/ve-ctrl-tool/pkg/mk2"
+	"github.com/yvesf/ve-ctrl-tool/pkg/mk2/broker"
 	"github.com/yvesf/ve-ctrl-tool/pkg/vebus"
 )
 
@@ -22,9 +23,39 @@ var (
 	flagVEAddress    = flag.Int("veAddress", 0, "Set other address than 0")
 	flagDebug        = flag.Bool("debug", false, "Set log level to debug")
 	flagMetricsHTTP  = flag.String("metricsHTTP", "", "Address of a http server serving metrics under /metrics")
+	flagBroker       = flag.String("broker", "", "Connect to the ve-broker unix socket instead of the serial device")
+	flagWait         = flag.Bool("wait", false, "Wait until the serial device is not used by another process")
 )
 
-func CommonInit(ctx context.Context) *mk2.Adapter {
+// CommonInit parses the flags, sets up logging and metrics and returns the adapter to the VE.Bus.
+// The adapter is connected via ve-broker if -broker is set, otherwise the serial device is opened.
+// The returned function releases the adapter.
+func CommonInit(ctx context.Context) (mk2.AdapterCommands, func()) {
+	Setup(ctx)
+
+	if *flagBroker != `` {
+		client, err := broker.Dial(ctx, *flagBroker)
+		if err != nil {
+			panic(err)
+		}
+
+		err = client.SetAddress(ctx, byte(*flagVEAddress))
+		if err != nil {
+			panic(err)
+		}
+
+		return client, func() { _ = client.Close() }
+	}
+
+	adapter := OpenSerialAdapter(ctx)
+	return adapter, func() {
+		adapter.Shutdown()
+		adapter.Wait()
+	}
+}
+
+// Setup parses the flags and sets up logging and the metrics http endpoint.
+func Setup(ctx context.Context) {
 	flag.Parse()
 
 	logLevel := slog.LevelInfo
@@ -38,37 +69,58 @@ func CommonInit(ctx context.Context) *mk2.Adapter {
 	if *flagMetricsHTTP != `` {
 		mux := http.NewServeMux()
 		mux.Handle("/metrics", omhttp.NewHandler(openmetrics.DefaultRegistry()))
+		ServeHTTP(ctx, *flagMetricsHTTP, mux)
+	}
+}
 
-		var lc net.ListenConfig
-		ln, err := lc.Listen(ctx, "tcp", *flagMetricsHTTP)
-		if err != nil {
-			slog.Error("Listen on http failed", slog.String("addr", *flagMetricsHTTP))
+// ServeHTTP serves handler on addr in the background. The process exits if the server fails.
+func ServeHTTP(ctx context.Context, addr string, handler http.Handler) {
+	var lc net.ListenConfig
+	ln, err := lc.Listen(ctx, "tcp", addr)
+	if err != nil {
+		slog.Error("Listen on http failed", slog.String("addr", addr))
+		os.Exit(1)
+	}
+
+	srv := &http.Server{Handler: handler}
+	go func() {
+		err := srv.Serve(ln)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("http server failed", slog.Any("err", err))
 			os.Exit(1)
 		}
+	}()
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+}
 
-		srv := &http.Server{Handler: mux}
-		go func() {
-			err := srv.Serve(ln)
-			if err != nil && !errors.Is(err, http.ErrServerClosed) {
-				slog.Error("http server failed", slog.Any("err", err))
-				os.Exit(1)
-			}
-		}()
+// OpenSerialAdapter opens the serial device, initializes the MK3 adapter and selects the VE.Bus address.
+func OpenSerialAdapter(ctx context.Context) *mk2.Adapter {
+	adapter, err := mk2.NewAdapter(*flagSerialDevice)
+	for *flagWait && errors.Is(err, mk2.ErrDeviceLocked) {
+		slog.Info("waiting for serial device", slog.Any("err", err))
+		select {
+		case <-ctx.Done():
+			os.Exit(1)
+		case <-time.After(time.Second * 5):
+		}
+		adapter, err = mk2.NewAdapter(*flagSerialDevice)
 	}
-
-	mk2, err := mk2.NewAdapter(*flagSerialDevice)
 	if err != nil {
-		panic(err)
+		slog.Error("failed to open serial device", slog.Any("err", err))
+		os.Exit(1)
 	}
 
 	// reset both in high and low speed
-	err = mk2.SetBaudHigh()
+	err = adapter.SetBaudHigh()
 	if err != nil {
 		panic(err)
 	}
-	mk2.Write(vebus.CommandR.Frame().Marshal())
+	adapter.Write(vebus.CommandR.Frame().Marshal())
 	time.Sleep(time.Second * 1)
-	err = mk2.SetBaudLow()
+	err = adapter.SetBaudLow()
 	if err != nil {
 		panic(err)
 	}
@@ -76,21 +128,26 @@ func CommonInit(ctx context.Context) *mk2.Adapter {
 	// The following is supposed to switch the MK3 adapter to High-Speed mode.
 	// This is undocumented and may break, therefore the switch to skip it.
 	if !*flagLow {
-		err := mk2.UpgradeHighSpeed()
+		err := adapter.UpgradeHighSpeed()
 		if err != nil {
 			panic(err)
 		}
 	}
 
-	err = mk2.StartReader()
+	err = adapter.StartReader()
 	if err != nil {
 		panic(err)
 	}
 
-	err = mk2.SetAddress(ctx, byte(*flagVEAddress))
+	err = adapter.SetAddress(ctx, byte(*flagVEAddress))
 	if err != nil {
 		panic(err)
 	}
 
-	return mk2
+	return adapter
+}
+
+// VEAddress returns the VE.Bus address selected by the -veAddress flag.
+func VEAddress() byte {
+	return byte(*flagVEAddress)
 }
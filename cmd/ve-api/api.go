@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/yvesf/ve-ctrl-tool/pkg/mk2"
+	"github.com/yvesf/ve-ctrl-tool/pkg/vebus"
+)
+
+// api serves the adapter commands as JSON endpoints.
+type api struct {
+	adapter mk2.AdapterCommands
+
+	// readOnly rejects all requests that change the device.
+	readOnly bool
+	// writableRAM and writableSettings are the IDs that can be written if not readOnly.
+	writableRAM      map[uint16]bool
+	writableSettings map[uint16]bool
+	// writableAddress allows changing the VE.Bus address if not readOnly.
+	writableAddress bool
+	// maxCharge and maxInverter [Watt] limit the ESS setpoint to -maxCharge..maxInverter, the setpoint cannot
+	// be written if both are zero.
+	maxCharge, maxInverter int
+
+	essLock sync.Mutex
+	ess     *mk2.AdapterWithESS // initialized on first setpoint request
+}
+
+type stateResponse struct {
+	State    mk2.DeviceStateResponseState    `json:"state"`
+	SubState mk2.DeviceStateResponseSubState `json:"subState"`
+}
+
+type addressValue struct {
+	Address byte `json:"address"`
+}
+
+type ramValue struct {
+	ID     uint16 `json:"id"`
+	Value  uint16 `json:"value"`
+	Signed int16  `json:"signed"`
+}
+
+type settingValue struct {
+	ID    uint16 `json:"id"`
+	Value uint16 `json:"value"`
+}
+
+// writeValue is the request body of all PUT requests except address.
+// Value may be given signed (-32768..-1) or unsigned (0..65535).
+type writeValue struct {
+	Value *int `json:"value"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (a *api) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/state", a.getState)
+	mux.HandleFunc("GET /api/address", a.getAddress)
+	mux.HandleFunc("PUT /api/address", a.putAddress)
+	mux.HandleFunc("GET /api/ram/{id}", a.getRAM)
+	mux.HandleFunc("PUT /api/ram/{id}", a.putRAM)
+	mux.HandleFunc("GET /api/setting/{id}", a.getSetting)
+	mux.HandleFunc("PUT /api/setting/{id}", a.putSetting)
+	mux.HandleFunc("PUT /api/ess/setpoint", a.putSetpoint)
+	return mux
+}
+
+func (a *api) getState(w http.ResponseWriter, r *http.Request) {
+	state, subState, err := a.adapter.CommandGetSetDeviceState(r.Context(), mk2.DeviceStateRequestStateInquiry)
+	if err != nil {
+		writeAdapterError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stateResponse{State: state, SubState: subState})
+}
+
+func (a *api) getAddress(w http.ResponseWriter, r *http.Request) {
+	address, err := a.adapter.GetAddress(r.Context())
+	if err != nil {
+		writeAdapterError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, addressValue{Address: address})
+}
+
+func (a *api) putAddress(w http.ResponseWriter, r *http.Request) {
+	if a.readOnly {
+		writeError(w, http.StatusForbidden, errors.New("read-only mode"))
+		return
+	}
+	if !a.writableAddress {
+		writeError(w, http.StatusForbidden, errors.New("address is not writable"))
+		return
+	}
+	var body addressValue
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	// the ESS record is only valid for the address it was discovered on.
+	a.essLock.Lock()
+	defer a.essLock.Unlock()
+	a.ess = nil
+
+	if err := a.adapter.SetAddress(r.Context(), body.Address); err != nil {
+		writeAdapterError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (a *api) getRAM(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 8)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ram id: %w", err))
+		return
+	}
+	value, _, err := a.adapter.CommandReadRAMVarUnsigned16(r.Context(), byte(id), 0)
+	if err != nil {
+		writeAdapterError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ramValue{ID: uint16(id), Value: value, Signed: vebus.ParseSigned16(value)})
+}
+
+func (a *api) putRAM(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.writableID(w, r, a.writableRAM)
+	if !ok {
+		return
+	}
+	value, ok := readWriteValue(w, r)
+	if !ok {
+		return
+	}
+	if !a.checkNotSetpointRAM(w, r, id) {
+		return
+	}
+	err := a.adapter.CommandWriteRAMVarData(r.Context(), id, byte(value), byte(value>>8))
+	if err != nil {
+		writeAdapterError(w, err)
+		return
+	}
+	slog.Info("ram written", slog.Int("id", int(id)), slog.Int("value", int(value)))
+	writeJSON(w, http.StatusOK, ramValue{ID: id, Value: value, Signed: vebus.ParseSigned16(value)})
+}
+
+func (a *api) getSetting(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 16)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid setting id: %w", err))
+		return
+	}
+	low, high, err := a.adapter.CommandReadSetting(r.Context(), byte(id), byte(id>>8))
+	if err != nil {
+		writeAdapterError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, settingValue{ID: uint16(id), Value: uint16(low) | uint16(high)<<8})
+}
+
+func (a *api) putSetting(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.writableID(w, r, a.writableSettings)
+	if !ok {
+		return
+	}
+	value, ok := readWriteValue(w, r)
+	if !ok {
+		return
+	}
+	err := a.adapter.CommandWriteSettingData(r.Context(), id, byte(value), byte(value>>8))
+	if err != nil {
+		writeAdapterError(w, err)
+		return
+	}
+	slog.Info("setting written", slog.Int("id", int(id)), slog.Int("value", int(value)))
+	writeJSON(w, http.StatusOK, settingValue{ID: id, Value: value})
+}
+
+// putSetpoint writes the ESS setpoint once, limited to -maxCharge..maxInverter. The ESS falls back to zero if
+// the setpoint is not written regularly, so the caller has to repeat the request.
+func (a *api) putSetpoint(w http.ResponseWriter, r *http.Request) {
+	if a.readOnly {
+		writeError(w, http.StatusForbidden, errors.New("read-only mode"))
+		return
+	}
+	if a.maxCharge == 0 && a.maxInverter == 0 {
+		writeError(w, http.StatusForbidden, errors.New("setpoint is not writable, no -maxCharge or -maxInverter"))
+		return
+	}
+	var body writeValue
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Value == nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid request body, expected {\"value\": <int16>}"))
+		return
+	}
+	if *body.Value < math.MinInt16 || *body.Value > math.MaxInt16 {
+		writeError(w, http.StatusBadRequest, errors.New("setpoint out of range"))
+		return
+	}
+	*body.Value = max(-a.maxCharge, min(a.maxInverter, *body.Value))
+
+	ess, err := a.essAdapter(r.Context())
+	if err != nil {
+		writeAdapterError(w, err)
+		return
+	}
+	err = ess.SetpointSet(r.Context(), int16(*body.Value))
+	if err != nil {
+		writeAdapterError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (a *api) essAdapter(ctx context.Context) (*mk2.AdapterWithESS, error) {
+	a.essLock.Lock()
+	defer a.essLock.Unlock()
+	if a.ess == nil {
+		ess, err := mk2.ESSInit(ctx, a.adapter)
+		if err != nil {
+			return nil, err
+		}
+		a.ess = ess
+	}
+	return a.ess, nil
+}
+
+// checkNotSetpointRAM rejects writes to the RAM ID of the ESS setpoint, it is only writable through putSetpoint
+// and its limits. It writes the error response and returns false if the id cannot be written.
+func (a *api) checkNotSetpointRAM(w http.ResponseWriter, r *http.Request, id uint16) bool {
+	if id < vebus.RAMIDAssistantFirst {
+		return true
+	}
+	ess, err := a.essAdapter(r.Context())
+	switch {
+	case errors.Is(err, mk2.ErrESSNotFound), errors.Is(err, mk2.ErrVariableNotSupported):
+		return true
+	case err != nil:
+		writeAdapterError(w, err)
+		return false
+	case id == ess.SetpointRAMID():
+		writeError(w, http.StatusForbidden, fmt.Errorf("ram id %d is the ESS setpoint, use /api/ess/setpoint", id))
+		return false
+	}
+	return true
+}
+
+// writableID parses the id path parameter and checks it against allowed. It writes the error response
+// and returns false if the id cannot be written.
+func (a *api) writableID(w http.ResponseWriter, r *http.Request, allowed map[uint16]bool) (uint16, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 16)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid id: %w", err))
+		return 0, false
+	}
+	if a.readOnly {
+		writeError(w, http.StatusForbidden, errors.New("read-only mode"))
+		return 0, false
+	}
+	if !allowed[uint16(id)] {
+		writeError(w, http.StatusForbidden, fmt.Errorf("id %d is not writable", id))
+		return 0, false
+	}
+	return uint16(id), true
+}
+
+// readWriteValue decodes writeValue from the request body and returns the value as 16 bit word.
+func readWriteValue(w http.ResponseWriter, r *http.Request) (uint16, bool) {
+	var body writeValue
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Value == nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid request body, expected {\"value\": <int>}"))
+		return 0, false
+	}
+	if *body.Value < math.MinInt16 || *body.Value > math.MaxUint16 {
+		writeError(w, http.StatusBadRequest, errors.New("value out of range"))
+		return 0, false
+	}
+	return uint16(*body.Value), true
+}
+
+func writeAdapterError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, mk2.ErrVariableNotSupported), errors.Is(err, mk2.ErrSettingNotSupported):
+		writeError(w, http.StatusNotFound, err)
+	default:
+		slog.Error("adapter command failed", slog.Any("err", err))
+		writeError(w, http.StatusBadGateway, err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		slog.Error("failed to encode json response", slog.Any("err", err))
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+
+	"github.com/yvesf/ve-ctrl-tool/pkg/mk2/mk2test"
+	"github.com/yvesf/ve-ctrl-tool/pkg/vebus"
+)
+
+func request(t *testing.T, handler http.Handler, method, path, body string) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	respBody, err := io.ReadAll(rec.Body)
+	be.NilErr(t, err)
+	return rec.Code, strings.TrimSpace(string(respBody))
+}
+
+func TestAPI(t *testing.T) {
+	newAPI := func() (*api, *mk2test.Fake) {
+		fake := mk2test.NewFake()
+		return &api{
+			adapter:          fake,
+			writableRAM:      map[uint16]bool{130: true},
+			writableSettings: map[uint16]bool{2: true},
+			writableAddress:  true,
+			maxCharge:        250,
+			maxInverter:      60,
+		}, fake
+	}
+
+	t.Run(`state`, func(t *testing.T) {
+		a, _ := newAPI()
+		code, body := request(t, a.routes(), http.MethodGet, "/api/state", "")
+		be.Equal(t, http.StatusOK, code)
+		be.Equal(t, `{"state":"charge","subState":"bulk"}`, body)
+	})
+	t.Run(`read ram`, func(t *testing.T) {
+		a, fake := newAPI()
+		fake.SetRAMSigned(vebus.RAMIDIBat, -12)
+		code, body := request(t, a.routes(), http.MethodGet, "/api/ram/5", "")
+		be.Equal(t, http.StatusOK, code)
+		be.Equal(t, `{"id":5,"value":65524,"signed":-12}`, body)
+	})
+	t.Run(`read ram not supported`, func(t *testing.T) {
+		a, _ := newAPI()
+		code, _ := request(t, a.routes(), http.MethodGet, "/api/ram/5", "")
+		be.Equal(t, http.StatusNotFound, code)
+	})
+	t.Run(`read setting`, func(t *testing.T) {
+		a, fake := newAPI()
+		fake.Settings[258] = 1000
+		code, body := request(t, a.routes(), http.MethodGet, "/api/setting/258", "")
+		be.Equal(t, http.StatusOK, code)
+		be.Equal(t, `{"id":258,"value":1000}`, body)
+		be.Equal(t, "CommandReadSetting[2 1]", fake.Calls()[0].String())
+	})
+	t.Run(`write allowed ram`, func(t *testing.T) {
+		a, fake := newAPI()
+		code, body := request(t, a.routes(), http.MethodPut, "/api/ram/130", `{"value":-200}`)
+		be.Equal(t, http.StatusOK, code)
+		be.Equal(t, `{"id":130,"value":65336,"signed":-200}`, body)
+		be.Equal(t, uint16(0xff38), fake.RAM[130])
+	})
+	t.Run(`write ram not in allowlist`, func(t *testing.T) {
+		a, fake := newAPI()
+		code, _ := request(t, a.routes(), http.MethodPut, "/api/ram/131", `{"value":1}`)
+		be.Equal(t, http.StatusForbidden, code)
+		be.Equal(t, 0, len(fake.Calls()))
+	})
+	t.Run(`write setting`, func(t *testing.T) {
+		a, fake := newAPI()
+		code, _ := request(t, a.routes(), http.MethodPut, "/api/setting/2", `{"value":513}`)
+		be.Equal(t, http.StatusOK, code)
+		be.Equal(t, "CommandWriteSettingData[2 1 2]", fake.Calls()[0].String())
+	})
+	t.Run(`invalid value`, func(t *testing.T) {
+		a, _ := newAPI()
+		code, _ := request(t, a.routes(), http.MethodPut, "/api/ram/130", `{"value":70000}`)
+		be.Equal(t, http.StatusBadRequest, code)
+		code, _ = request(t, a.routes(), http.MethodPut, "/api/ram/130", `{}`)
+		be.Equal(t, http.StatusBadRequest, code)
+	})
+	t.Run(`read-only`, func(t *testing.T) {
+		a, fake := newAPI()
+		a.readOnly = true
+		for _, path := range []string{"/api/ram/130", "/api/setting/2", "/api/ess/setpoint", "/api/address"} {
+			code, _ := request(t, a.routes(), http.MethodPut, path, `{"value":1,"address":1}`)
+			be.Equal(t, http.StatusForbidden, code)
+		}
+		be.Equal(t, 0, len(fake.Calls()))
+	})
+	t.Run(`ess setpoint`, func(t *testing.T) {
+		a, fake := newAPI()
+		fake.RAM[128] = 0x0052 // ESS assistant
+		code, _ := request(t, a.routes(), http.MethodPut, "/api/ess/setpoint", `{"value":-150}`)
+		be.Equal(t, http.StatusOK, code)
+		code, _ = request(t, a.routes(), http.MethodPut, "/api/ess/setpoint", `{"value":-100}`)
+		be.Equal(t, http.StatusOK, code)
+		be.Equal(t, uint16(0xff9c), fake.RAM[129])
+		be.Equal(t, 1, len(fake.CallsTo("CommandReadRAMVar"))) // ESS record discovered only once
+	})
+	t.Run(`ess setpoint limited`, func(t *testing.T) {
+		a, fake := newAPI()
+		fake.RAM[128] = 0x0052 // ESS assistant
+		code, body := request(t, a.routes(), http.MethodPut, "/api/ess/setpoint", `{"value":-32000}`)
+		be.Equal(t, http.StatusOK, code)
+		be.Equal(t, `{"value":-250}`, body)
+		be.Equal(t, uint16(0xff06), fake.RAM[129])
+		code, body = request(t, a.routes(), http.MethodPut, "/api/ess/setpoint", `{"value":5000}`)
+		be.Equal(t, http.StatusOK, code)
+		be.Equal(t, `{"value":60}`, body)
+		be.Equal(t, uint16(60), fake.RAM[129])
+	})
+	t.Run(`ess setpoint ram not writable`, func(t *testing.T) {
+		a, fake := newAPI()
+		fake.RAM[128] = 0x0052 // ESS assistant
+		a.writableRAM[129] = true
+		code, body := request(t, a.routes(), http.MethodPut, "/api/ram/129", `{"value":-32000}`)
+		be.Equal(t, http.StatusForbidden, code)
+		be.In(t, "use /api/ess/setpoint", body)
+		be.Equal(t, 0, len(fake.CallsTo("CommandWriteRAMVarData")))
+		code, _ = request(t, a.routes(), http.MethodPut, "/api/ram/130", `{"value":1}`)
+		be.Equal(t, http.StatusOK, code)
+		be.Equal(t, uint16(1), fake.RAM[130])
+	})
+	t.Run(`ess setpoint without limits`, func(t *testing.T) {
+		a, fake := newAPI()
+		a.maxCharge, a.maxInverter = 0, 0
+		code, _ := request(t, a.routes(), http.MethodPut, "/api/ess/setpoint", `{"value":-100}`)
+		be.Equal(t, http.StatusForbidden, code)
+		be.Equal(t, 0, len(fake.Calls()))
+	})
+	t.Run(`address not writable`, func(t *testing.T) {
+		a, fake := newAPI()
+		a.writableAddress = false
+		code, _ := request(t, a.routes(), http.MethodPut, "/api/address", `{"address":2}`)
+		be.Equal(t, http.StatusForbidden, code)
+		be.Equal(t, 0, len(fake.Calls()))
+	})
+	t.Run(`address`, func(t *testing.T) {
+		a, fake := newAPI()
+		code, _ := request(t, a.routes(), http.MethodPut, "/api/address", `{"address":2}`)
+		be.Equal(t, http.StatusOK, code)
+		be.Equal(t, byte(2), fake.Address)
+		code, body := request(t, a.routes(), http.MethodGet, "/api/address", "")
+		be.Equal(t, http.StatusOK, code)
+		be.Equal(t, `{"address":2}`, body)
+	})
+}
@@ -0,0 +1,74 @@
+// package main implements a HTTP server exposing the adapter commands as JSON endpoints.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/yvesf/ve-ctrl-tool/cmd"
+)
+
+var (
+	flagAPIHTTP          = flag.String("apiHTTP", "127.0.0.1:18002", "Address of the http server serving the API")
+	flagReadOnly         = flag.Bool("readOnly", false, "Reject all requests that change the device")
+	flagWritableRAM      = flag.String("writableRAM", "", "Comma separated list of RAM IDs that can be written")
+	flagWritableSettings = flag.String("writableSettings", "", "Comma separated list of setting IDs that can be written")
+	flagWritableAddress  = flag.Bool("writableAddress", false, "Allow changing the VE.Bus address")
+	flagMaxCharge        = flag.Int("maxCharge", 0, "Maximum ESS Setpoint for charging (negative setpoint)")
+	flagMaxInverter      = flag.Int("maxInverter", 0, "Maximum ESS Setpoint for inverter (positive setpoint)")
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	adapter, release := cmd.CommonInit(ctx)
+	defer release()
+
+	writableRAM, err := parseIDList(*flagWritableRAM)
+	if err != nil {
+		slog.Error("invalid -writableRAM", slog.Any("err", err))
+		os.Exit(1)
+	}
+	writableSettings, err := parseIDList(*flagWritableSettings)
+	if err != nil {
+		slog.Error("invalid -writableSettings", slog.Any("err", err))
+		os.Exit(1)
+	}
+
+	a := &api{
+		adapter:          adapter,
+		readOnly:         *flagReadOnly,
+		writableRAM:      writableRAM,
+		writableSettings: writableSettings,
+		writableAddress:  *flagWritableAddress,
+		maxCharge:        max(0, *flagMaxCharge),
+		maxInverter:      max(0, *flagMaxInverter),
+	}
+	cmd.ServeHTTP(ctx, *flagAPIHTTP, a.routes())
+	slog.Info("serving api", slog.String("addr", *flagAPIHTTP), slog.Bool("readOnly", *flagReadOnly))
+
+	<-ctx.Done()
+}
+
+func parseIDList(s string) (map[uint16]bool, error) {
+	ids := make(map[uint16]bool)
+	if s == "" {
+		return ids, nil
+	}
+	for _, v := range strings.Split(s, ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(v), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse id %q: %w", v, err)
+		}
+		ids[uint16(id)] = true
+	}
+	return ids, nil
+}
@@ -0,0 +1,57 @@
+// package main implements a daemon that owns the serial device and shares it with other processes
+// (ve-shell, ve-ess-shelly started with -broker) over a unix socket.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/yvesf/ve-ctrl-tool/cmd"
+	"github.com/yvesf/ve-ctrl-tool/pkg/mk2/broker"
+)
+
+var flagSocket = flag.String("socket", "/run/ve-broker/ve-broker.sock", "Path of the unix socket to listen on")
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	cmd.Setup(ctx)
+
+	adapter := cmd.OpenSerialAdapter(ctx)
+	defer adapter.Wait()
+	defer adapter.Shutdown()
+
+	// remove the socket of a previous run
+	if fi, err := os.Stat(*flagSocket); err == nil && fi.Mode().Type() == fs.ModeSocket {
+		_ = os.Remove(*flagSocket)
+	}
+
+	var lc net.ListenConfig
+	ln, err := lc.Listen(ctx, "unix", *flagSocket)
+	if err != nil {
+		slog.Error("listen failed", slog.String("socket", *flagSocket), slog.Any("err", err))
+		os.Exit(1)
+	}
+
+	// access is controlled by group ownership of the socket
+	err = os.Chmod(*flagSocket, 0o660)
+	if err != nil {
+		slog.Error("failed to set permissions on socket", slog.Any("err", err))
+		os.Exit(1)
+	}
+
+	slog.Info("serving", slog.String("socket", *flagSocket))
+	err = broker.NewServer(adapter, cmd.VEAddress()).Serve(ctx, ln)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		slog.Error("serve failed", slog.Any("err", err))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+// fakeClock is a clock with a virtual time that only moves with Advance.
+type fakeClock struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) timer {
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	c.lock.Lock()
+	c.timers = append(c.timers, t)
+	c.lock.Unlock()
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the time forward by d and fires the timers that are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.timers {
+		t.fireIfDue()
+	}
+}
+
+// advanceToTimer waits until a timer is active and moves the time forward to it. It returns the duration
+// the time moved.
+func (c *fakeClock) advanceToTimer(t *testing.T) time.Duration {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.lock.Lock()
+		var next *fakeTimer
+		for _, timer := range c.timers {
+			if timer.active && (next == nil || timer.at.Before(next.at)) {
+				next = timer
+			}
+		}
+		c.lock.Unlock()
+		if next != nil {
+			d := next.at.Sub(c.Now())
+			c.Advance(d)
+			return d
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("no active timer")
+	return 0
+}
+
+// fakeTimer behaves like a time.Timer since Go 1.23, no stale value is received after Reset or Stop.
+type fakeTimer struct {
+	clock  *fakeClock
+	c      chan time.Time
+	at     time.Time
+	active bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	active := t.stop()
+	t.at, t.active = t.clock.now.Add(d), true
+	t.fireIfDue()
+	return active
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	return t.stop()
+}
+
+func (t *fakeTimer) stop() bool {
+	active := t.active
+	t.active = false
+	select {
+	case <-t.c: // not received yet counts as active
+		active = true
+	default:
+	}
+	return active
+}
+
+// fireIfDue sends the time if the timer is due, the lock of the clock must be held.
+func (t *fakeTimer) fireIfDue() {
+	if t.active && !t.at.After(t.clock.now) {
+		t.active = false
+		t.c <- t.at
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	c := newFakeClock()
+	start := c.Now()
+	tm := c.NewTimer(time.Second)
+	c.Advance(999 * time.Millisecond)
+	be.Equal(t, 0, len(tm.C()))
+	c.Advance(time.Millisecond)
+	be.Equal(t, start.Add(time.Second), <-tm.C())
+
+	// no stale value after Reset
+	tm.Reset(0)
+	be.True(t, tm.Reset(time.Second))
+	be.Equal(t, 0, len(tm.C()))
+	be.True(t, tm.Stop())
+	c.Advance(time.Hour)
+	be.Equal(t, 0, len(tm.C()))
+	be.False(t, tm.Stop())
+}
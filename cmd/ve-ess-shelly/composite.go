@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// compositeMeter implements PushMeter for an arithmetic expression over several meters, e.g. "main - heatpump".
+// Each source is read by its own meterReader with its own plausibility checks. The time of the measurement
+// is the time of the oldest source and no measurement is provided while a source is invalid, so the
+// composite measurement turns stale if any source is stale.
+type compositeMeter struct {
+	expr    *expression
+	sources map[string]*meterReader
+}
+
+// newCompositeMeter returns the meter for expr with the meter addresses of the sources by name. Each
+// source is checked with check and polled readings are smoothed by filter (nil for the default).
+func newCompositeMeter(expr string, addrs map[string]string, check plausibility, filter func() meterFilter,
+) (EnergyMeter, error) {
+	e, err := parseExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+	for name := range addrs {
+		if !slices.Contains(e.names, name) {
+			return nil, fmt.Errorf("source %v is not used in the meter expression", name)
+		}
+	}
+	sources := make(map[string]*meterReader, len(e.names))
+	for _, name := range e.names {
+		addr, ok := addrs[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown source %v in the meter expression", name)
+		}
+		meter, err := newEnergyMeter(addr)
+		if err != nil {
+			return nil, fmt.Errorf("source %v: %w", name, err)
+		}
+		sources[name] = &meterReader{Meter: meter, Name: name, Plausibility: check, Filter: filter}
+	}
+	return compositeMeter{expr: e, sources: sources}, nil
+}
+
+// Read evaluates the last measurements of the sources. The sources are only read while subscribed.
+func (c compositeMeter) Read(context.Context) (Measurement, error) {
+	m, _, ok := c.measurement()
+	if !ok {
+		return Measurement{}, errors.New("no valid measurement of all sources")
+	}
+	return m, nil
+}
+
+// Subscribe runs the meterReader of each source and provides the evaluated expression whenever the
+// measurement of a source advanced. It fails if a source fails.
+func (c compositeMeter) Subscribe(ctx context.Context, fn func(Measurement)) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	failed := make(chan error, len(c.sources))
+	updated := make(chan struct{}, 1)
+	for name, source := range c.sources {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			err := source.Run(ctx)
+			if err != nil {
+				failed <- fmt.Errorf("source %v: %w", name, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-source.Updated():
+				}
+				select {
+				case updated <- struct{}{}:
+				default:
+				}
+			}
+		}()
+	}
+
+	var pushed map[string]time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-failed:
+			return err
+		case <-updated:
+		}
+		m, times, ok := c.measurement()
+		if !ok {
+			continue
+		}
+		advanced := false
+		for name, at := range times {
+			advanced = advanced || at.After(pushed[name])
+		}
+		if advanced {
+			pushed = times
+			fn(m)
+		}
+	}
+}
+
+// SetInverterPower passes the inverter power to the plausibility checks of the sources, it may flow
+// through any of them.
+func (c compositeMeter) SetInverterPower(watt float64) {
+	for _, source := range c.sources {
+		source.SetInverterPower(watt)
+	}
+}
+
+// PhaseCount is known if it is known for all sources. Phases are only evaluated if all sources provide the same
+// number of phases, a source without phases or sources with different counts make it zero.
+func (c compositeMeter) PhaseCount() (int, bool) {
+	counts := make(map[int]bool)
+	known := true
+	for _, source := range c.sources {
+		n, ok := phaseCount(source.Meter)
+		if ok {
+			counts[n] = true
+		} else {
+			known = false
+		}
+	}
+	if counts[0] || len(counts) > 1 {
+		return 0, true
+	}
+	if !known {
+		return 0, false
+	}
+	for n := range counts {
+		return n, true
+	}
+	return 0, false
+}
+
+// measurement evaluates the expression with the last measurements of the sources and returns the times of
+// the source measurements. Phases are evaluated if all sources provide the same number of phases. It
+// returns false if a source has no valid measurement.
+func (c compositeMeter) measurement() (Measurement, map[string]time.Time, bool) {
+	var (
+		oldest time.Time
+		times  = make(map[string]time.Time, len(c.sources))
+		totals = make(map[string]float64, len(c.sources))
+		phases []map[string]float64
+	)
+	phaseCount := -1
+	for name, source := range c.sources {
+		m, at := source.last()
+		if at.IsZero() {
+			return Measurement{}, nil, false
+		}
+		times[name] = at
+		if oldest.IsZero() || at.Before(oldest) {
+			oldest = at
+		}
+		totals[name] = m.Total.ConsumptionPositive()
+
+		if phaseCount == -1 {
+			phaseCount = len(m.Phases)
+			for range phaseCount {
+				phases = append(phases, make(map[string]float64, len(c.sources)))
+			}
+		}
+		if len(m.Phases) != phaseCount {
+			phaseCount = 0
+		}
+		for i := range min(phaseCount, len(m.Phases)) {
+			phases[i][name] = m.Phases[i].Power.ConsumptionPositive()
+		}
+	}
+
+	result := Measurement{Total: ConsumptionPositive(c.expr.eval(totals)), Time: oldest}
+	for i := range max(phaseCount, 0) {
+		result.Phases = append(result.Phases, PhaseMeasurement{Power: ConsumptionPositive(c.expr.eval(phases[i]))})
+	}
+	return result, times, true
+}
+
+// sourcesFlag collects the sources of a composite meter from repeated -source name=address flags.
+type sourcesFlag map[string]string
+
+func (s sourcesFlag) String() string {
+	var sources []string
+	for _, name := range slices.Sorted(maps.Keys(s)) {
+		sources = append(sources, name+"="+s[name])
+	}
+	return strings.Join(sources, " ")
+}
+
+func (s sourcesFlag) Set(value string) error {
+	name, addr, ok := strings.Cut(value, "=")
+	if !ok || !isIdentifier(name) || addr == "" {
+		return fmt.Errorf("expected name=address, got %q", value)
+	}
+	if _, exists := s[name]; exists {
+		return fmt.Errorf("duplicate source %v", name)
+	}
+	s[name] = addr
+	return nil
+}
+
+// expression is an arithmetic expression over named values with the operators + - * / and parentheses.
+type expression struct {
+	// names are the names of the values used in the expression, sorted.
+	names []string
+	eval  func(values map[string]float64) float64
+}
+
+func parseExpression(s string) (*expression, error) {
+	p := &expressionParser{s: s}
+	eval, err := p.sum()
+	if err == nil && p.peek() != 0 {
+		err = fmt.Errorf("unexpected %q at position %d", p.peek(), p.pos+1)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid meter expression %q: %w", s, err)
+	}
+	slices.Sort(p.names)
+	return &expression{names: slices.Compact(p.names), eval: eval}, nil
+}
+
+// expressionParser is a recursive descent parser, each method parses one level of precedence.
+type expressionParser struct {
+	s     string
+	pos   int
+	names []string
+}
+
+// peek returns the next character that is not a space, 0 at the end.
+func (p *expressionParser) peek() byte {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+	if p.pos == len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+// sum parses term {(+|-) term}.
+func (p *expressionParser) sum() (func(map[string]float64) float64, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == '+' {
+			left = func(v map[string]float64) float64 { return l(v) + right(v) }
+		} else {
+			left = func(v map[string]float64) float64 { return l(v) - right(v) }
+		}
+	}
+	return left, nil
+}
+
+// term parses factor {(*|/) factor}.
+func (p *expressionParser) term() (func(map[string]float64) float64, error) {
+	left, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		right, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == '*' {
+			left = func(v map[string]float64) float64 { return l(v) * right(v) }
+		} else {
+			left = func(v map[string]float64) float64 { return l(v) / right(v) }
+		}
+	}
+	return left, nil
+}
+
+// factor parses -factor, (sum), a number or a name.
+func (p *expressionParser) factor() (func(map[string]float64) float64, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return nil, errors.New("unexpected end")
+	case c == '-':
+		p.pos++
+		f, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return func(v map[string]float64) float64 { return -f(v) }, nil
+	case c == '(':
+		p.pos++
+		f, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) at position %d", p.pos+1)
+		}
+		p.pos++
+		return f, nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.s) && (isIdentifierChar(p.s[p.pos]) || p.s[p.pos] == '.') {
+		p.pos++
+	}
+	token := p.s[start:p.pos]
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected %q at position %d", c, start+1)
+	case c >= '0' && c <= '9':
+		number, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", token)
+		}
+		return func(map[string]float64) float64 { return number }, nil
+	case isIdentifier(token):
+		p.names = append(p.names, token)
+		return func(v map[string]float64) float64 { return v[token] }, nil
+	default:
+		return nil, fmt.Errorf("invalid name %q", token)
+	}
+}
+
+// isIdentifier reports whether s is a valid source name, letters, digits and _ not starting with a digit.
+func isIdentifier(s string) bool {
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		return false
+	}
+	for i := range len(s) {
+		if !isIdentifierChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isIdentifierChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestParseExpression(t *testing.T) {
+	values := map[string]float64{"main": 1000, "heatpump": 300, "em_1": 20}
+	for _, tc := range []struct {
+		expr   string
+		names  []string
+		result float64
+	}{
+		{expr: "main", names: []string{"main"}, result: 1000},
+		{expr: "main - heatpump", names: []string{"heatpump", "main"}, result: 700},
+		{expr: "main-heatpump-em_1", names: []string{"em_1", "heatpump", "main"}, result: 680},
+		{expr: "main - (heatpump - em_1)", names: []string{"em_1", "heatpump", "main"}, result: 720},
+		{expr: "main + 2 * em_1", names: []string{"em_1", "main"}, result: 1040},
+		{expr: "-main / 4 + main", names: []string{"main"}, result: 750},
+		{expr: "0.5*(main+main)", names: []string{"main"}, result: 1000},
+	} {
+		t.Run(tc.expr, func(t *testing.T) {
+			e, err := parseExpression(tc.expr)
+			be.NilErr(t, err)
+			be.AllEqual(t, tc.names, e.names)
+			be.Equal(t, tc.result, e.eval(values))
+		})
+	}
+
+	for _, expr := range []string{"", "main -", "(main", "main)", "main heatpump", "1main", "main % 2", "1.2.3"} {
+		t.Run(`invalid `+expr, func(t *testing.T) {
+			_, err := parseExpression(expr)
+			be.Nonzero(t, err)
+		})
+	}
+}
+
+func TestNewCompositeMeter(t *testing.T) {
+	check := plausibility{FrozenAfter: time.Minute}
+	m, err := newCompositeMeter("main - heatpump", map[string]string{
+		"main":     "shelly://10.1.0.210",
+		"heatpump": "shelly-em1://10.1.0.211",
+	}, check, nil)
+	be.NilErr(t, err)
+	be.Equal(t, 2, len(m.(compositeMeter).sources))
+	be.Equal(t, "heatpump", m.(compositeMeter).sources["heatpump"].Name)
+	be.Equal(t, time.Minute, m.(compositeMeter).sources["heatpump"].Plausibility.FrozenAfter)
+
+	_, err = newCompositeMeter("main - heatpump", map[string]string{"main": "10.1.0.210"}, check, nil)
+	be.Nonzero(t, err)
+	_, err = newCompositeMeter("main", map[string]string{"main": "10.1.0.210", "other": "10.1.0.211"}, check, nil)
+	be.Nonzero(t, err)
+	_, err = newCompositeMeter("main", map[string]string{"main": "foo://bar"}, check, nil)
+	be.Nonzero(t, err)
+}
+
+func TestCompositeMeter_filter(t *testing.T) {
+	for _, tc := range []struct {
+		filter   string
+		expected PowerFlowWatt
+	}{
+		{filter: "mean:5", expected: 200},
+		{filter: "none", expected: 0},
+	} {
+		t.Run(tc.filter, func(t *testing.T) {
+			filter, err := newMeterFilter(tc.filter)
+			be.NilErr(t, err)
+			m, err := newCompositeMeter("main - heatpump", map[string]string{
+				"main":     "shelly://10.1.0.210",
+				"heatpump": "shelly://10.1.0.211",
+			}, plausibility{}, filter)
+			be.NilErr(t, err)
+
+			// the sources are polled with the filter of the composite meter.
+			clock := newFakeClock()
+			main := make(pollMeterMock)
+			c := m.(compositeMeter)
+			c.sources["main"].Meter, c.sources["main"].Clock = main, clock
+			c.sources["heatpump"].Meter = pushMeterMock{push: 0}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			reader := &meterReader{Meter: c}
+			done := make(chan error)
+			go func() { done <- reader.Run(ctx) }()
+			main <- pollResult{measurement: Measurement{Total: 400}}
+			waitForMeasurement(t, reader, 400)
+			clock.advanceToTimer(t)
+			main <- pollResult{measurement: Measurement{Total: 0}}
+			waitForMeasurement(t, reader, tc.expected)
+			cancel()
+			be.NilErr(t, <-done)
+		})
+	}
+}
+
+func TestCompositeMeter_measurement(t *testing.T) {
+	e, err := parseExpression("main - heatpump")
+	be.NilErr(t, err)
+	main, heatpump := &meterReader{Name: "main"}, &meterReader{Name: "heatpump"}
+	c := compositeMeter{expr: e, sources: map[string]*meterReader{"main": main, "heatpump": heatpump}}
+
+	main.pushed(Measurement{Total: 600, Phases: []PhaseMeasurement{{Power: 100}, {Power: 200}, {Power: 300}}})
+	_, _, ok := c.measurement()
+	be.False(t, ok) // no measurement of heatpump
+
+	// the time is the time of the oldest source
+	old := time.Now().Add(-time.Minute)
+	heatpump.pushed(Measurement{Total: 150, Time: old})
+	m, times, ok := c.measurement()
+	be.True(t, ok)
+	be.Equal(t, old, times["heatpump"])
+	be.Equal(t, PowerFlowWatt(450), m.Total)
+	be.Equal(t, old, m.Time)
+	be.Equal(t, 0, len(m.Phases)) // heatpump has no phases
+
+	heatpump.pushed(Measurement{Total: 150, Phases: []PhaseMeasurement{{Power: 50}, {Power: 50}, {Power: 50}}})
+	m, _, ok = c.measurement()
+	be.True(t, ok)
+	be.Equal(t, 3, len(m.Phases))
+	be.Equal(t, PowerFlowWatt(250), m.Phases[2].Power)
+
+	// an implausible source invalidates the measurement
+	heatpump.Plausibility = plausibility{MaxStep: 1000}
+	heatpump.pushed(Measurement{Total: 150})
+	heatpump.pushed(Measurement{Total: 5000})
+	_, _, ok = c.measurement()
+	be.False(t, ok)
+}
+
+func TestCompositeMeter_Subscribe(t *testing.T) {
+	e, err := parseExpression("main - heatpump")
+	be.NilErr(t, err)
+	c := compositeMeter{expr: e, sources: map[string]*meterReader{
+		"main":     {Meter: pushMeterMock{push: 600}, Name: "main"},
+		"heatpump": {Meter: pushMeterMock{push: 150}, Name: "heatpump"},
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &meterReader{Meter: c}
+	done := make(chan error)
+	go func() { done <- m.Run(ctx) }()
+	waitForMeasurement(t, m, 450)
+
+	// the sources push once, the measurement is not repeated
+	select {
+	case <-m.Updated():
+	case <-time.After(time.Second):
+	}
+	select {
+	case <-m.Updated():
+		t.Error("measurement pushed again")
+	case <-time.After(300 * time.Millisecond):
+	}
+	cancel()
+	be.NilErr(t, <-done)
+}
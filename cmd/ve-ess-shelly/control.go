@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// controlSettings are the settings of the control loop that can be changed at runtime.
+type controlSettings struct {
+	// Paused keeps the ESS setpoint at zero.
+	Paused bool `json:"paused"`
+	// MaxCharge [Watt] is the maximum power to charge the battery (negative ESS setpoint).
+	MaxCharge int `json:"maxCharge"`
+	// MaxInverter [Watt] is the maximum power to generate (positive ESS setpoint).
+	MaxInverter int `json:"maxInverter"`
+	// Target [Watt] is the power flow at the meter to regulate to, positive=consumption.
+	Target int `json:"target"`
+	// Mode selects the power flow that is regulated, the total or per phase.
+	Mode regulationMode `json:"mode"`
+	// Kp, Ki and Kd are the gains of the PID controller.
+	Kp float64 `json:"kp"`
+	Ki float64 `json:"ki"`
+	Kd float64 `json:"kd"`
+	// FallbackSetpoint [Watt] is held while no valid meter measurement is available, limited by MaxCharge and
+	// MaxInverter. It is not held while Paused.
+	FallbackSetpoint int `json:"fallbackSetpoint"`
+}
+
+// controlState is the last known state of the control loop.
+type controlState struct {
+	Setpoint    int16   `json:"setpoint"`
+	PIDInput    float64 `json:"pidInput"`
+	UBat        float64 `json:"uBat"`
+	IBat        float64 `json:"iBat"`
+	DeviceState string  `json:"deviceState"`
+	// MeterUp is false while no valid meter measurement is available and the fallback setpoint is held.
+	MeterUp bool `json:"meterUp"`
+}
+
+// control is shared by the control loop and the remote interfaces.
+type control struct {
+	lock     sync.Mutex
+	settings controlSettings
+	state    controlState
+}
+
+func newControl(settings controlSettings) *control {
+	return &control{settings: settings}
+}
+
+func (c *control) Settings() controlSettings {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.settings
+}
+
+func (c *control) UpdateSettings(update func(*controlSettings)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	update(&c.settings)
+}
+
+func (c *control) State() controlState {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.state
+}
+
+// MarshalJSON returns the settings and the state as one object.
+func (c *control) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		controlSettings
+		controlState
+	}{c.Settings(), c.State()})
+}
+
+func (c *control) updateState(update func(*controlState)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	update(&c.state)
+}
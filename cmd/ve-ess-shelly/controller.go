@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math"
@@ -10,11 +11,17 @@ import (
 	"github.com/bsm/openmetrics"
 )
 
-var metricControlInput = openmetrics.DefaultRegistry().Gauge(openmetrics.Desc{
-	Name: "ess_pid_input",
-	Unit: "watt",
-	Help: "The current input for the PID controller",
-})
+var (
+	metricControlInput = openmetrics.DefaultRegistry().Gauge(openmetrics.Desc{
+		Name: "ess_pid_input",
+		Unit: "watt",
+		Help: "The current input for the PID controller",
+	})
+	metricMeterUp = openmetrics.DefaultRegistry().Gauge(openmetrics.Desc{
+		Name: "ess_meter_up",
+		Help: "1 if a valid meter measurement is available, 0 while the fallback setpoint is held",
+	})
+)
 
 type ESSControl interface {
 	Stats(ctx context.Context) (EssStats, error)
@@ -22,78 +29,162 @@ type ESSControl interface {
 	SetZero(ctx context.Context) error
 }
 
+const (
+	// setpointKeepAlive is the interval the unchanged setpoint is rewritten in. The ESS shuts down for safety
+	// reasons if the setpoint is not written about every 30s.
+	setpointKeepAlive = 20 * time.Second
+	// statsInterval is the interval the ESS statistics are read in.
+	statsInterval = 10 * time.Second
+	// meterMaxAge is the age after which a measurement is not used anymore.
+	meterMaxAge = 10 * time.Second
+)
+
 // Run starts the control loop.
 // The control loop is blocking and can be stopped by cancelling ctx.
-func RunController(ctx context.Context, ess ESSControl, meter *meterReader) error {
+// The controller is stepped on every new measurement of meter, the time between the measurements is the
+// time step of the PID controller. The settings of ctrl are applied on every iteration and the state of
+// ctrl is updated. The controller uses the clock of meter.
+func RunController(ctx context.Context, ess ESSControl, meter *meterReader, ctrl *control) error {
 	var (
-		pidLastUpdateAt       time.Time
-		lastStatsUpdateAt     time.Time
-		lastSetpointWrittenAt time.Time
-		lastSetpointValue     float64
+		pidLastMeasurementAt time.Time
+		lastSetpointValue    float64
 	)
 
-	pidC := NewPIDWithMetrics(0.15, 0.1, 0.15)
-	pidC.SetOutputLimits(-1*float64(*SettingsMaxWattCharge), float64(*SettingsMaxWattInverter))
-
-controlLoop:
-	for {
+	meterUp := false
+	setMeterUp(ctrl, meterUp)
+
+	clock := meter.clock()
+	settings := ctrl.Settings()
+	pidC := NewPIDController(settings.Kp, settings.Ki, settings.Kd)
+	pidC.SetOutputLimits(-1*float64(settings.MaxCharge), float64(settings.MaxInverter))
+
+	// keepAlive and statsTimer fire immediately to write the initial setpoint and read the initial stats.
+	// The timers are reset before writing to the ESS, so the next event is scheduled once the ESS got the
+	// command.
+	keepAlive := clock.NewTimer(0)
+	defer keepAlive.Stop()
+	statsTimer := clock.NewTimer(0)
+	defer statsTimer.Stop()
+	// stale fires when the last measurement reaches meterMaxAge.
+	stale := clock.NewTimer(meterMaxAge)
+	defer stale.Stop()
+
+	for ctx.Err() == nil {
+		rewrite := false
 		select {
 		case <-ctx.Done():
-			break controlLoop
-		case <-time.After(time.Millisecond * 25):
-		}
-
-		m, lastMeasurement := meter.LastMeasurement()
-		if lastMeasurement.IsZero() || time.Since(lastMeasurement) > time.Second*10 {
-			slog.Info("no energy meter information", slog.Time("lastMeasurement", lastMeasurement))
-			err := ess.SetZero(ctx)
+			continue
+		case <-meter.Updated():
+		case <-stale.C():
+		case <-keepAlive.C():
+			rewrite = true
+		case <-statsTimer.C():
+			statsTimer.Reset(statsInterval)
+			stats, err := ess.Stats(ctx)
 			if err != nil {
-				return err
+				return fmt.Errorf("failed to read ESS stats: %w", err)
 			}
+			meter.SetInverterPower(float64(stats.InverterPower))
+			ctrl.updateState(func(s *controlState) {
+				s.UBat, s.IBat, s.DeviceState = stats.UBat, stats.IBat, string(stats.State)
+			})
 			continue
 		}
 
-		controllerInputM := m.ConsumptionNegative() + float64(*SettingsPowerOffset)
-		metricControlInput.With().Set(controllerInputM)
-
-		if pidLastUpdateAt.IsZero() {
-			pidLastUpdateAt = time.Now()
+		previous := settings
+		settings = ctrl.Settings()
+		if settings.MaxCharge != previous.MaxCharge || settings.MaxInverter != previous.MaxInverter {
+			pidC.SetOutputLimits(-1*float64(settings.MaxCharge), float64(settings.MaxInverter))
+		}
+		if settings.Kp != previous.Kp || settings.Ki != previous.Ki || settings.Kd != previous.Kd {
+			pidC.SetGains(settings.Kp, settings.Ki, settings.Kd)
 		}
-		// Take consumption negative to regulate to 0.
-		controllerOut := pidC.UpdateDuration(controllerInputM, time.Since(pidLastUpdateAt))
-		pidLastUpdateAt = time.Now()
 
-		// round PID output to reduce the need for updating the setpoint for marginal changes.
-		controllerOut = math.Round(controllerOut/float64(*SettingsSetpointRounding)) * float64(*SettingsSetpointRounding)
+		now := clock.Now()
+		regulated, measuredAt, err := meterPower(meter, settings.Mode, now)
+		if up := err == nil; up != meterUp {
+			meterUp = up
+			setMeterUp(ctrl, up)
+			switch {
+			case up:
+				slog.Info("energy meter available")
+			case errors.Is(err, errMissingPhases):
+				slog.Error("energy meter does not provide the phases of the regulation mode, holding fallback setpoint",
+					slog.String("mode", string(settings.Mode)),
+					slog.Int("fallbackSetpoint", settings.FallbackSetpoint), slog.Any("err", err))
+			default:
+				slog.Warn("no energy meter information, holding fallback setpoint",
+					slog.Int("fallbackSetpoint", settings.FallbackSetpoint), slog.Any("err", err))
+			}
+		}
+		if meterUp {
+			stale.Reset(measuredAt.Add(meterMaxAge).Sub(now))
+		}
 
-		// output zero around values +/- 10 around the control point.
-		if controllerOut > -1*float64(*SettingsZeroPointWindow) && controllerOut < float64(*SettingsZeroPointWindow) {
-			controllerOut = 0
+		// without a new measurement the last setpoint is kept.
+		controllerOut := lastSetpointValue
+		if !meterUp {
+			pidLastMeasurementAt = time.Time{}
+			controllerOut = max(-float64(settings.MaxCharge),
+				min(float64(settings.MaxInverter), float64(settings.FallbackSetpoint)))
+			if settings.Paused {
+				controllerOut = 0
+			}
+			// the PID controller continues from the fallback setpoint once the meter is available again.
+			pidC.Track(controllerOut)
+			if controllerOut == 0 {
+				if rewrite || lastSetpointValue != 0 {
+					keepAlive.Reset(setpointKeepAlive)
+					err := ess.SetZero(ctx)
+					if err != nil {
+						return err
+					}
+					lastSetpointValue = 0
+					ctrl.updateState(func(s *controlState) { s.Setpoint = 0 })
+				}
+				continue
+			}
+		} else if measuredAt.After(pidLastMeasurementAt) {
+			controllerInputM := regulated.ConsumptionNegative() + float64(*SettingsPowerOffset) +
+				float64(settings.Target)
+			metricControlInput.With().Set(controllerInputM)
+			ctrl.updateState(func(s *controlState) { s.PIDInput = controllerInputM })
+
+			if settings.Paused {
+				pidLastMeasurementAt = time.Time{}
+				controllerOut = 0
+				pidC.Track(controllerOut)
+			} else {
+				var dt time.Duration
+				if !pidLastMeasurementAt.IsZero() {
+					dt = measuredAt.Sub(pidLastMeasurementAt)
+				}
+				pidLastMeasurementAt = measuredAt
+				// Take consumption negative to regulate to 0.
+				controllerOut = pidC.UpdateDuration(controllerInputM, dt)
+
+				// round PID output to reduce the need for updating the setpoint for marginal changes.
+				controllerOut = math.Round(controllerOut/float64(*SettingsSetpointRounding)) *
+					float64(*SettingsSetpointRounding)
+
+				// output zero around values +/- 10 around the control point.
+				if controllerOut > -1*float64(*SettingsZeroPointWindow) &&
+					controllerOut < float64(*SettingsZeroPointWindow) {
+					controllerOut = 0
+				}
+			}
 		}
 
-		// only update the ESS if
-		// - value is different from last update.
-		// - the value haven't been updated yet.
-		// - 15 seconds passed. We have to write about every 30s to not let the ESS shutdown for safety reasons.
-		if controllerOut != lastSetpointValue ||
-			lastSetpointWrittenAt.IsZero() ||
-			time.Since(lastSetpointWrittenAt) > time.Second*20 {
+		// only update the ESS if the value is different from the last update or on keep-alive.
+		if controllerOut != lastSetpointValue || rewrite {
+			keepAlive.Reset(setpointKeepAlive)
 			err := ess.SetpointSet(ctx, int16(controllerOut))
 			if err != nil {
 				return fmt.Errorf("failed to write ESS setpoint: %w", err)
 			}
 
 			lastSetpointValue = controllerOut
-			lastSetpointWrittenAt = time.Now()
-		}
-
-		// collect statistics only every 10 seconds.
-		if lastStatsUpdateAt.IsZero() || time.Since(lastStatsUpdateAt) > time.Second*10 {
-			_, err := ess.Stats(ctx)
-			if err != nil {
-				return fmt.Errorf("failed to read ESS stats: %w", err)
-			}
-			lastStatsUpdateAt = time.Now()
+			ctrl.updateState(func(s *controlState) { s.Setpoint = int16(controllerOut) })
 		}
 	}
 
@@ -108,3 +199,26 @@ controlLoop:
 
 	return ctx.Err()
 }
+
+// meterPower returns the power flow of the last measurement to regulate to zero and the time of the
+// measurement. It fails if the measurement is invalid or reached meterMaxAge at time now.
+func meterPower(meter *meterReader, mode regulationMode, now time.Time) (PowerFlowWatt, time.Time, error) {
+	m, lastMeasurement := meter.last()
+	if lastMeasurement.IsZero() {
+		return 0, time.Time{}, errors.New("no valid measurement")
+	}
+	if age := now.Sub(lastMeasurement); age >= meterMaxAge {
+		return 0, time.Time{}, fmt.Errorf("last measurement %v ago", age.Round(time.Second))
+	}
+	power, err := mode.power(m.Total, m.Phases)
+	return power, lastMeasurement, err
+}
+
+func setMeterUp(ctrl *control, up bool) {
+	ctrl.updateState(func(s *controlState) { s.MeterUp = up })
+	if up {
+		metricMeterUp.With().Set(1)
+	} else {
+		metricMeterUp.With().Set(0)
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/carlmjohnson/be"
 )
@@ -10,6 +13,7 @@ import (
 type essMock struct {
 	setpointSet func(context.Context, int16) error
 	stats       func(context.Context) (EssStats, error)
+	setZero     func(context.Context) error
 }
 
 func (m *essMock) SetpointSet(ctx context.Context, value int16) error {
@@ -20,8 +24,11 @@ func (m *essMock) Stats(ctx context.Context) (EssStats, error) {
 	return m.stats(ctx)
 }
 
-func (m *essMock) SetZero(context.Context) error {
-	return nil
+func (m *essMock) SetZero(ctx context.Context) error {
+	if m.setZero == nil {
+		return nil
+	}
+	return m.setZero(ctx)
 }
 
 func TestRunController__exitOnCancelledContext(t *testing.T) {
@@ -33,6 +40,267 @@ func TestRunController__exitOnCancelledContext(t *testing.T) {
 		return nil
 	}
 
-	err := RunController(ctx, essMock, nil)
+	err := RunController(ctx, essMock, &meterReader{}, newControl(controlSettings{}))
 	be.Equal(t, context.Canceled, err)
 }
+
+// testSettings are the limits and the default gains of the flags.
+var testSettings = controlSettings{MaxCharge: 250, MaxInverter: 60, Kp: 0.15, Ki: 0.1, Kd: 0.15}
+
+// controllerTest runs RunController on a virtual clock and records the calls to the ESS.
+type controllerTest struct {
+	t      *testing.T
+	clock  *fakeClock
+	meter  *meterReader
+	ctrl   *control
+	calls  chan string
+	cancel context.CancelFunc
+	done   chan error
+}
+
+func startController(t *testing.T, settings controlSettings) *controllerTest {
+	t.Helper()
+	clock := newFakeClock()
+	c := &controllerTest{
+		t:     t,
+		clock: clock,
+		meter: &meterReader{Clock: clock, Plausibility: plausibility{MaxPower: 35000}},
+		ctrl:  newControl(settings),
+		calls: make(chan string, 100),
+		done:  make(chan error),
+	}
+	essMock := &essMock{
+		setpointSet: func(_ context.Context, value int16) error {
+			c.calls <- fmt.Sprintf("setpoint %d", value)
+			return nil
+		},
+		stats: func(context.Context) (EssStats, error) {
+			c.calls <- "stats"
+			return EssStats{}, nil
+		},
+		setZero: func(context.Context) error {
+			c.calls <- "zero"
+			return nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	go func() { c.done <- RunController(ctx, essMock, c.meter, c.ctrl) }()
+	t.Cleanup(cancel)
+	return c
+}
+
+// measure provides a measurement at the current virtual time.
+func (c *controllerTest) measure(watt float64) {
+	c.meter.pushed(Measurement{Total: ConsumptionPositive(watt)})
+}
+
+// expect waits for the calls to the ESS in any order.
+func (c *controllerTest) expect(calls ...string) {
+	c.t.Helper()
+	var got []string
+	for range calls {
+		select {
+		case call := <-c.calls:
+			got = append(got, call)
+		case <-time.After(5 * time.Second):
+			c.t.Fatalf("expected calls %v, got %v", calls, got)
+		}
+	}
+	slices.Sort(got)
+	be.AllEqual(c.t, slices.Sorted(slices.Values(calls)), got)
+}
+
+// stop cancels the controller and checks that no other call than the reset on shutdown follows.
+func (c *controllerTest) stop() {
+	c.t.Helper()
+	c.cancel()
+	be.Equal(c.t, context.Canceled, <-c.done)
+	c.expect("setpoint 0")
+	be.Equal(c.t, 0, len(c.calls))
+}
+
+// setFlag sets the value of a flag for the test.
+func setFlag[T any](t *testing.T, flag *T, value T) {
+	previous := *flag
+	*flag = value
+	t.Cleanup(func() { *flag = previous })
+}
+
+func TestRunController__stepOnMeasurement(t *testing.T) {
+	c := startController(t, testSettings)
+	c.expect("zero", "stats")
+
+	// the time step of the PID controller is the interval between the measurements.
+	c.measure(-1000)
+	c.expect("setpoint -150") // proportional part only
+	c.clock.Advance(time.Second)
+	c.measure(-1000)
+	c.expect("setpoint -249") // integral of 1s added
+	c.stop()
+}
+
+func TestRunController__fallbackSetpoint(t *testing.T) {
+	settings := testSettings
+	settings.FallbackSetpoint = 100
+	c := startController(t, settings)
+
+	// the meter never provides a measurement
+	c.expect("setpoint 60", "stats") // limited to maxInverter
+	c.clock.Advance(10 * time.Second)
+	c.expect("stats")
+	c.clock.Advance(10 * time.Second)
+	c.expect("setpoint 60", "stats")
+	be.False(t, c.ctrl.State().MeterUp)
+	c.stop()
+}
+
+func TestRunController__pausedWithoutMeter(t *testing.T) {
+	settings := testSettings
+	settings.FallbackSetpoint = 100
+	settings.Paused = true
+	c := startController(t, settings)
+
+	// the setpoint is kept at zero instead of the fallback setpoint while paused.
+	c.expect("zero", "stats")
+	c.clock.Advance(10 * time.Second)
+	c.expect("stats")
+	c.clock.Advance(10 * time.Second)
+	c.expect("zero", "stats")
+	be.False(t, c.ctrl.State().MeterUp)
+	c.stop()
+}
+
+func TestRunController__resumeFromFallbackSetpoint(t *testing.T) {
+	settings := testSettings
+	settings.FallbackSetpoint = 51
+	c := startController(t, settings)
+	c.expect("setpoint 51", "stats")
+
+	// the PID controller continues from the fallback setpoint without error.
+	for range 2 {
+		c.measure(-4) // offset -4
+		c.clock.Advance(5 * time.Second)
+	}
+	c.expect("stats")
+	for range 2 {
+		c.measure(-4)
+		c.clock.Advance(5 * time.Second)
+	}
+	c.expect("setpoint 51", "stats")
+	be.True(t, c.ctrl.State().MeterUp)
+	c.stop()
+}
+
+func TestRunController__changeGains(t *testing.T) {
+	c := startController(t, testSettings)
+	c.expect("zero", "stats")
+	c.measure(-1000)
+	c.expect("setpoint -150")
+
+	// the integral term compensates the changed proportional term.
+	c.ctrl.UpdateSettings(func(s *controlSettings) { s.Kp = 0.2 })
+	c.clock.Advance(time.Second)
+	c.measure(-1000)
+	c.expect("setpoint -249") // -199.2 + 49.8 - 99.6
+	c.stop()
+}
+
+func TestRunController__keepAlive(t *testing.T) {
+	setFlag(t, SettingsPowerOffset, 0)
+	c := startController(t, testSettings)
+	c.expect("zero", "stats")
+
+	// the unchanged setpoint is only written every 20s.
+	for range 2 {
+		c.measure(0)
+		c.clock.Advance(5 * time.Second)
+	}
+	c.expect("stats")
+	for range 2 {
+		c.measure(0)
+		c.clock.Advance(5 * time.Second)
+	}
+	c.expect("setpoint 0", "stats")
+	be.True(t, c.ctrl.State().MeterUp)
+	c.stop()
+}
+
+func TestRunController__staleMeter(t *testing.T) {
+	c := startController(t, testSettings)
+	c.expect("zero", "stats")
+	c.measure(-1000)
+	c.expect("setpoint -150")
+
+	c.clock.Advance(9 * time.Second)
+	c.clock.Advance(time.Second)
+	c.expect("zero", "stats")
+	be.False(t, c.ctrl.State().MeterUp)
+
+	// SetZero is repeated as keep-alive.
+	c.clock.Advance(10 * time.Second)
+	c.expect("stats")
+	c.clock.Advance(10 * time.Second)
+	c.expect("zero", "stats")
+
+	// an implausible reading sets the meter invalid immediately.
+	c.measure(-1000)
+	c.expect("setpoint -150")
+	c.measure(50000)
+	c.expect("zero")
+	c.stop()
+	be.Equal(t, 0, c.ctrl.State().Setpoint)
+}
+
+func TestRunController__rounding(t *testing.T) {
+	setFlag(t, SettingsPowerOffset, 0)
+	for _, tc := range []struct {
+		rounding int
+		setpoint string
+	}{
+		{rounding: 1, setpoint: "setpoint -72"},
+		{rounding: 10, setpoint: "setpoint -70"},
+		{rounding: 20, setpoint: "setpoint -80"},
+	} {
+		setFlag(t, SettingsSetpointRounding, tc.rounding)
+		c := startController(t, testSettings)
+		c.expect("zero", "stats")
+		c.measure(-480) // P=0.15
+		c.expect(tc.setpoint)
+		c.stop()
+	}
+}
+
+func TestRunController__zeroWindow(t *testing.T) {
+	setFlag(t, SettingsPowerOffset, 0)
+	for _, tc := range []struct {
+		window   int
+		setpoint string
+	}{
+		{window: 10, setpoint: "setpoint 0"},
+		{window: 5, setpoint: "setpoint -6"},
+	} {
+		setFlag(t, SettingsZeroPointWindow, tc.window)
+		c := startController(t, testSettings)
+		c.expect("zero", "stats")
+		c.measure(-1000)
+		c.expect("setpoint -150")
+		c.clock.Advance(time.Second)
+		c.measure(-390) // P+I+D = -58.5 - 39 + 91.5
+		c.expect(tc.setpoint)
+		c.stop()
+	}
+}
+
+func TestRunController__statsInterval(t *testing.T) {
+	c := startController(t, testSettings)
+	c.expect("zero", "stats")
+	c.clock.Advance(9999 * time.Millisecond)
+	c.clock.Advance(time.Millisecond)
+	c.expect("stats")
+	c.clock.Advance(10 * time.Second)
+	c.expect("zero", "stats")
+	c.clock.Advance(10 * time.Second)
+	c.expect("stats")
+	c.stop()
+}
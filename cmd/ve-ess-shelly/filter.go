@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yvesf/ve-ctrl-tool/pkg/ringbuf"
+)
+
+// meterFilter smooths the total power readings of a polled meter.
+type meterFilter interface {
+	Add(value float64, at time.Time)
+	// Value returns the filtered value at time now.
+	Value(now time.Time) float64
+}
+
+// ringbufFilter applies value on the last samples.
+type ringbufFilter struct {
+	buf   *ringbuf.Ringbuf
+	value func(*ringbuf.Ringbuf) float64
+}
+
+func (f ringbufFilter) Add(v float64, _ time.Time) { f.buf.Add(v) }
+
+func (f ringbufFilter) Value(time.Time) float64 { return f.value(f.buf) }
+
+type timeWindowFilter struct {
+	*ringbuf.TimeWindow
+}
+
+func (f timeWindowFilter) Value(now time.Time) float64 { return f.Mean(now) }
+
+type ewmaFilter struct {
+	*ringbuf.EWMA
+}
+
+func (f ewmaFilter) Value(time.Time) float64 { return f.EWMA.Value() }
+
+// defaultMeterFilter is the mean of the last 5 readings.
+const defaultMeterFilter = "mean:5"
+
+// newMeterFilter returns the constructor of the filter spec name[:parameter]:
+//
+//	mean:N       mean of the last N readings (default 5)
+//	median:N     median of the last N readings (default 5)
+//	trimmed:N    mean of the last N readings without the lowest and highest 20% (default 10)
+//	window:D     mean of the readings within the duration D weighted by their interval (default 4s)
+//	ewma:TAU     exponentially weighted moving average with time constant TAU (default 2s)
+//	none         the last reading
+func newMeterFilter(spec string) (func() meterFilter, error) {
+	name, param, hasParam := strings.Cut(spec, ":")
+	size := func(def int) (int, error) {
+		if !hasParam {
+			return def, nil
+		}
+		n, err := strconv.Atoi(param)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid size %q of meter filter %v", param, name)
+		}
+		return n, nil
+	}
+	duration := func(def time.Duration) (time.Duration, error) {
+		if !hasParam {
+			return def, nil
+		}
+		d, err := time.ParseDuration(param)
+		if err != nil || d <= 0 {
+			return 0, fmt.Errorf("invalid duration %q of meter filter %v", param, name)
+		}
+		return d, nil
+	}
+	ringbufFilterOf := func(def int, value func(*ringbuf.Ringbuf) float64) (func() meterFilter, error) {
+		n, err := size(def)
+		if err != nil {
+			return nil, err
+		}
+		return func() meterFilter { return ringbufFilter{buf: ringbuf.NewRingbuf(n), value: value} }, nil
+	}
+
+	switch name {
+	case "mean":
+		return ringbufFilterOf(5, (*ringbuf.Ringbuf).Mean)
+	case "median":
+		return ringbufFilterOf(5, (*ringbuf.Ringbuf).Median)
+	case "trimmed":
+		return ringbufFilterOf(10, func(r *ringbuf.Ringbuf) float64 { return r.TrimmedMean(0.2) })
+	case "none":
+		return ringbufFilterOf(1, (*ringbuf.Ringbuf).Mean)
+	case "window":
+		d, err := duration(4 * time.Second)
+		if err != nil {
+			return nil, err
+		}
+		return func() meterFilter { return timeWindowFilter{ringbuf.NewTimeWindow(d)} }, nil
+	case "ewma":
+		tau, err := duration(2 * time.Second)
+		if err != nil {
+			return nil, err
+		}
+		return func() meterFilter { return ewmaFilter{ringbuf.NewEWMA(tau)} }, nil
+	default:
+		return nil, fmt.Errorf("unknown meter filter %q, one of: mean, median, trimmed, window, ewma, none", name)
+	}
+}
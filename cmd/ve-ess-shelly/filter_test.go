@@ -0,0 +1,47 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestNewMeterFilter(t *testing.T) {
+	for _, spec := range []string{"foo:3", "mean:0", "median:x", "window:-1s", "ewma:2"} {
+		_, err := newMeterFilter(spec)
+		be.True(t, err != nil)
+	}
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	readings := []float64{100, 100, 1000, 100, 100}
+	for _, tc := range []struct {
+		spec  string
+		value float64
+	}{
+		{spec: "mean:5", value: 280},
+		{spec: "mean:2", value: 100},
+		{spec: "median", value: 100},
+		{spec: "trimmed:5", value: 100},
+		{spec: "none", value: 100},
+		{spec: "window:2s", value: 100},
+		{spec: "window:4s", value: 325},
+	} {
+		newFilter, err := newMeterFilter(tc.spec)
+		be.NilErr(t, err)
+		f := newFilter()
+		be.True(t, math.IsNaN(f.Value(start)))
+		for i, v := range readings {
+			f.Add(v, start.Add(time.Duration(i)*time.Second))
+		}
+		be.Equal(t, tc.value, f.Value(start.Add(4*time.Second)))
+	}
+
+	newFilter, err := newMeterFilter("ewma:1s")
+	be.NilErr(t, err)
+	f := newFilter()
+	f.Add(0, start)
+	f.Add(100, start.Add(time.Second))
+	be.True(t, math.Abs(f.Value(start)-100*(1-math.Exp(-1))) < 1e-9)
+}
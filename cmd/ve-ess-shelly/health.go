@@ -0,0 +1,22 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// healthHandler responds with the settings and state of ctrl as JSON. The status is 503 Service Unavailable
+// while no valid meter measurement is available.
+func healthHandler(ctrl *control) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if !ctrl.State().MeterUp {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		err := json.NewEncoder(w).Encode(ctrl)
+		if err != nil {
+			slog.Debug("failed to write health response", slog.Any("err", err))
+		}
+	})
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestHealthHandler(t *testing.T) {
+	ctrl := newControl(controlSettings{MaxCharge: 250})
+	handler := healthHandler(ctrl)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	be.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	setMeterUp(ctrl, true)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	be.Equal(t, http.StatusOK, rec.Code)
+	var state map[string]any
+	be.NilErr(t, json.Unmarshal(rec.Body.Bytes(), &state))
+	be.Equal(t, true, state["meterUp"])
+	be.Equal(t, 250.0, state["maxCharge"])
+}
@@ -36,12 +36,13 @@ var (
 type EssStats struct {
 	IBat, UBat    float64
 	InverterPower int
+	State         mk2.DeviceStateResponseState
 }
 
 // inverter implements the ESSControl interface. It supports controlling the setpoint and gathering status information
 // on the Victron ESS via mk2.
 type inverter struct {
-	adapter *mk2.AdapterWithESS
+	adapter mk2.ESSCommands
 }
 
 func (m inverter) SetpointSet(ctx context.Context, value int16) error {
@@ -69,6 +70,11 @@ func (m inverter) Stats(ctx context.Context) (EssStats, error) {
 		return EssStats{}, fmt.Errorf("failed to read InverterPower1: %w", err)
 	}
 
+	state, _, err := m.adapter.CommandGetSetDeviceState(ctx, mk2.DeviceStateRequestStateInquiry)
+	if err != nil {
+		return EssStats{}, fmt.Errorf("failed to read device state: %w", err)
+	}
+
 	slog.Debug("multiplus stats", slog.Float64("IBat", float64(iBat)/10),
 		slog.Float64("UBat", float64(uBat)/100),
 		slog.Float64("InverterPower", float64(inverterPowerRAM)))
@@ -77,6 +83,7 @@ func (m inverter) Stats(ctx context.Context) (EssStats, error) {
 		IBat:          float64(iBat) / 10,
 		UBat:          float64(uBat) / 100,
 		InverterPower: int(inverterPowerRAM),
+		State:         state,
 	}
 
 	metricMultiplusIBat.With().Set(float64(stats.IBat))
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+
+	"github.com/yvesf/ve-ctrl-tool/pkg/mk2/mk2test"
+	"github.com/yvesf/ve-ctrl-tool/pkg/vebus"
+)
+
+func TestInverter(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run(`stats`, func(t *testing.T) {
+		fake := mk2test.NewFake()
+		fake.SetRAMSigned(vebus.RAMIDIBat, -123)
+		fake.SetRAMSigned(vebus.RAMIDUBat, 1321)
+		fake.SetRAMSigned(vebus.RAMIDInverterPower1, -150)
+
+		stats, err := inverter{adapter: fake}.Stats(ctx)
+		be.NilErr(t, err)
+		be.Equal(t, EssStats{IBat: -12.3, UBat: 13.21, InverterPower: -150, State: "charge"}, stats)
+	})
+	t.Run(`stats read error`, func(t *testing.T) {
+		fake := mk2test.NewFake()
+		injected := errors.New("injected")
+		fake.FailNext("CommandReadRAMVarSigned16", injected)
+
+		_, err := inverter{adapter: fake}.Stats(ctx)
+		be.True(t, errors.Is(err, injected))
+	})
+	t.Run(`setpoint and zero`, func(t *testing.T) {
+		fake := mk2test.NewFake()
+		inv := inverter{adapter: fake}
+		be.NilErr(t, inv.SetpointSet(ctx, -100))
+		be.Equal(t, int16(-100), fake.Setpoint)
+		be.NilErr(t, inv.SetZero(ctx))
+		be.Equal(t, int16(0), fake.Setpoint)
+		be.Equal(t, 2, len(fake.CallsTo("SetpointSet")))
+	})
+}
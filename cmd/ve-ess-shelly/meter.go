@@ -0,0 +1,600 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goburrow/serial"
+
+	"github.com/yvesf/ve-ctrl-tool/pkg/modbusmeter"
+	"github.com/yvesf/ve-ctrl-tool/pkg/p1"
+	"github.com/yvesf/ve-ctrl-tool/pkg/shelly"
+	"github.com/yvesf/ve-ctrl-tool/pkg/sml"
+)
+
+// EnergyMeter is a source of power measurements at the grid connection point.
+type EnergyMeter interface {
+	// Read returns the current measurement.
+	Read(ctx context.Context) (Measurement, error)
+}
+
+// PushMeter is an EnergyMeter that pushes measurements as soon as they are available. Read is used as
+// fallback while Subscribe is not available.
+type PushMeter interface {
+	EnergyMeter
+	// Subscribe calls fn on every new measurement. It blocks until ctx is cancelled (returns nil) or
+	// the subscription fails.
+	Subscribe(ctx context.Context, fn func(Measurement)) error
+}
+
+// phaseCounter is implemented by meters that know the number of phases of their measurements.
+type phaseCounter interface {
+	// PhaseCount returns the number of phases of every measurement. ok is false if it is only known from
+	// the measurements.
+	PhaseCount() (n int, ok bool)
+}
+
+// phaseCount returns the number of phases of the measurements of meter, ok is false if it is unknown.
+func phaseCount(meter EnergyMeter) (n int, ok bool) {
+	if c, isCounter := meter.(phaseCounter); isCounter {
+		return c.PhaseCount()
+	}
+	return 0, false
+}
+
+// Measurement is a single reading of an EnergyMeter.
+type Measurement struct {
+	// Total is the power flow summed over all phases.
+	Total PowerFlowWatt
+	// Phases are the readings per phase in the order L1, L2, L3. Empty if the meter does not provide them.
+	Phases []PhaseMeasurement
+	// NeutralCurrent [Ampere] is nil if not measured.
+	NeutralCurrent *float64
+	// Frequency [Hz] is zero if not measured.
+	Frequency float64
+	// Time is the time of the measurement. Zero means the time it was read or pushed.
+	Time time.Time
+}
+
+// PhaseMeasurement is the reading of a single phase.
+type PhaseMeasurement struct {
+	Power         PowerFlowWatt
+	Voltage       float64 // [Volt]
+	Current       float64 // [Ampere]
+	ApparentPower float64 // [VA]
+	PowerFactor   float64
+}
+
+// meterBackends maps the URL scheme of the meter address to the constructor of the EnergyMeter.
+var meterBackends = map[string]func(u *url.URL) (EnergyMeter, error){
+	"shelly":      newShellyGen2Meter,
+	"http":        newShellyGen2Meter,
+	"shelly-ws":   newShellyWSMeter,
+	"shelly-gen1": newShellyGen1Meter,
+	"shelly-em1":  newShellyEM1Meter,
+	"modbus-tcp":  newModbusMeter,
+	"modbus-rtu":  newModbusMeter,
+	"sml":         newSMLMeter,
+	"p1":          newP1Meter,
+	"p1-tcp":      newP1Meter,
+	"mqtt":        newMQTTMeter,
+}
+
+// newEnergyMeter returns the EnergyMeter for addr. The backend is selected by the URL scheme,
+// a plain host name or address without scheme is a Shelly Gen2 device.
+func newEnergyMeter(addr string) (EnergyMeter, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "shelly://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid meter address %q: %w", addr, err)
+	}
+	newMeter, ok := meterBackends[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported meter type %q", u.Scheme)
+	}
+	return newMeter(u)
+}
+
+// shellyGen2Meter implements EnergyMeter for Shelly Gen2 devices (Shelly Pro 3EM).
+type shellyGen2Meter struct {
+	shelly.Gen2Meter
+}
+
+func newShellyGen2Meter(u *url.URL) (EnergyMeter, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in meter address")
+	}
+	ids, err := queryInts(u, "id")
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) > 1 {
+		return nil, fmt.Errorf("only one EM component id is supported")
+	}
+	id := 0
+	if len(ids) == 1 {
+		id = ids[0]
+	}
+	client, err := shellyGen2Client()
+	if err != nil {
+		return nil, err
+	}
+	return shellyGen2Meter{shelly.Gen2Meter{Addr: u.Host, Client: client, ID: id}}, nil
+}
+
+func (s shellyGen2Meter) Read(ctx context.Context) (Measurement, error) {
+	data, err := s.Gen2Meter.Read(ctx)
+	if err != nil {
+		return Measurement{}, err
+	}
+	return gen2Measurement(*data), nil
+}
+
+func gen2Measurement(data shelly.Gen2MeterData) Measurement {
+	m := Measurement{
+		Total:          ConsumptionPositive(data.TotalPower()),
+		NeutralCurrent: data.NeutralCurrent,
+	}
+	for _, p := range data.Phases {
+		m.Phases = append(m.Phases, PhaseMeasurement{
+			Power:         ConsumptionPositive(p.ActivePower),
+			Voltage:       p.Voltage,
+			Current:       p.Current,
+			ApparentPower: p.ApparentPower,
+			PowerFactor:   p.PowerFactor,
+		})
+	}
+	return m
+}
+
+// shellyWSMeter implements PushMeter for Shelly Gen2 devices with the status notifications of the
+// websocket RPC channel. Read polls like shellyGen2Meter.
+type shellyWSMeter struct {
+	shellyGen2Meter
+	stream shelly.Gen2Stream
+}
+
+func newShellyWSMeter(u *url.URL) (EnergyMeter, error) {
+	m, err := newShellyGen2Meter(u)
+	if err != nil {
+		return nil, err
+	}
+	password, err := shelly.LoadPassword(*flagShellyPasswordFile)
+	if err != nil {
+		return nil, err
+	}
+	gen2 := m.(shellyGen2Meter)
+	return shellyWSMeter{
+		shellyGen2Meter: gen2,
+		stream:          shelly.Gen2Stream{Addr: gen2.Addr, ID: gen2.ID, Password: password},
+	}, nil
+}
+
+func (s shellyWSMeter) Subscribe(ctx context.Context, fn func(Measurement)) error {
+	return s.stream.Run(ctx, func(data shelly.Gen2MeterData) {
+		fn(gen2Measurement(data))
+	})
+}
+
+// shellyGen1Meter implements EnergyMeter for the original Shelly 3EM.
+type shellyGen1Meter struct {
+	shelly.Gen1Meter
+}
+
+func newShellyGen1Meter(u *url.URL) (EnergyMeter, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in meter address")
+	}
+	client := shelly.NewClient(shellyClientOptions())
+	return shellyGen1Meter{shelly.Gen1Meter{Addr: u.Host, Client: client}}, nil
+}
+
+func (s shellyGen1Meter) Read(ctx context.Context) (Measurement, error) {
+	data, err := s.Gen1Meter.Read(ctx)
+	if err != nil {
+		return Measurement{}, err
+	}
+	m := Measurement{Total: ConsumptionPositive(data.TotalPower())}
+	for _, e := range data.EMeters {
+		m.Phases = append(m.Phases, PhaseMeasurement{
+			Power:         ConsumptionPositive(e.Power),
+			Voltage:       e.Voltage,
+			Current:       e.Current,
+			ApparentPower: e.Voltage * e.Current,
+			PowerFactor:   e.PowerFactor,
+		})
+	}
+	return m, nil
+}
+
+// shellyEM1Meter implements EnergyMeter for single phase Shelly Gen2 devices (Shelly Pro EM, EM Mini Gen3).
+// The total is the sum of the selected channels. A channel may measure a PV or load clamp instead of a phase,
+// the channels are reported as phases L1, L2, L3 in the order of the channels query parameter only
+// with phases=true.
+type shellyEM1Meter struct {
+	shelly.Gen2EM1Meter
+	Phases bool
+}
+
+func newShellyEM1Meter(u *url.URL) (EnergyMeter, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in meter address")
+	}
+	channels, err := queryInts(u, "channels")
+	if err != nil {
+		return nil, err
+	}
+	if len(channels) == 0 {
+		channels = []int{0}
+	}
+	var phases bool
+	if value := u.Query().Get("phases"); value != "" {
+		phases, err = strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for phases: %w", err)
+		}
+	}
+	client, err := shellyGen2Client()
+	if err != nil {
+		return nil, err
+	}
+	return shellyEM1Meter{
+		Gen2EM1Meter: shelly.Gen2EM1Meter{Addr: u.Host, Client: client, Channels: channels},
+		Phases:       phases,
+	}, nil
+}
+
+func (s shellyEM1Meter) PhaseCount() (int, bool) {
+	if !s.Phases {
+		return 0, true
+	}
+	return len(s.Channels), true
+}
+
+func (s shellyEM1Meter) Read(ctx context.Context) (Measurement, error) {
+	data, err := s.Gen2EM1Meter.Read(ctx)
+	if err != nil {
+		return Measurement{}, err
+	}
+	m := Measurement{Total: ConsumptionPositive(data.TotalPower())}
+	for _, c := range data.Channels {
+		m.Frequency = c.Frequency
+		if !s.Phases {
+			continue
+		}
+		m.Phases = append(m.Phases, PhaseMeasurement{
+			Power:         ConsumptionPositive(c.ActivePower),
+			Voltage:       c.Voltage,
+			Current:       c.Current,
+			ApparentPower: c.ApparentPower,
+			PowerFactor:   c.PowerFactor,
+		})
+	}
+	return m, nil
+}
+
+// modbusMeter implements EnergyMeter for Modbus meters (Eastron SDM630/SDM72/SDM120, Chint DTSU666).
+type modbusMeter struct {
+	modbusmeter.Meter
+}
+
+// newModbusMeter returns the meter for modbus-tcp://host:port?model=sdm630&unit=1 or
+// modbus-rtu:///dev/ttyUSB0?model=sdm630&unit=1&baud=9600&parity=N.
+func newModbusMeter(u *url.URL) (EnergyMeter, error) {
+	model, ok := modbusmeter.Models[u.Query().Get("model")]
+	if !ok {
+		names := slices.Sorted(maps.Keys(modbusmeter.Models))
+		return nil, fmt.Errorf("unknown or missing model, one of: %s", strings.Join(names, ", "))
+	}
+	units, err := queryInts(u, "unit")
+	if err != nil {
+		return nil, err
+	}
+	if len(units) > 1 {
+		return nil, fmt.Errorf("only one modbus unit id is supported")
+	}
+	unitID := byte(1)
+	if len(units) == 1 {
+		unitID = byte(units[0])
+	}
+
+	var client modbusmeter.Client
+	switch u.Scheme {
+	case "modbus-tcp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("missing host in meter address")
+		}
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "502")
+		}
+		client = &modbusmeter.TCPClient{Addr: addr, UnitID: unitID}
+	case "modbus-rtu":
+		if u.Path == "" {
+			return nil, fmt.Errorf("missing serial device in meter address")
+		}
+		port, err := openSerial(u, time.Second)
+		if err != nil {
+			return nil, err
+		}
+		client = &modbusmeter.RTUClient{Port: port, UnitID: unitID}
+	}
+	return modbusMeter{modbusmeter.Meter{Client: client, Model: model}}, nil
+}
+
+func (m modbusMeter) PhaseCount() (int, bool) {
+	return len(m.Model.Phases), true
+}
+
+func (m modbusMeter) Read(ctx context.Context) (Measurement, error) {
+	data, err := m.Meter.Read(ctx)
+	if err != nil {
+		return Measurement{}, err
+	}
+	measurement := Measurement{Total: ConsumptionPositive(data.Power), Frequency: data.Frequency}
+	for _, p := range data.Phases {
+		phase := PhaseMeasurement{
+			Power:         ConsumptionPositive(p.Power),
+			Voltage:       p.Voltage,
+			Current:       p.Current,
+			ApparentPower: p.Voltage * p.Current,
+		}
+		if phase.ApparentPower > 0 {
+			phase.PowerFactor = p.Power / phase.ApparentPower
+		}
+		measurement.Phases = append(measurement.Phases, phase)
+	}
+	return measurement, nil
+}
+
+// smlMeter implements PushMeter for utility meters sending SML on the optical interface.
+type smlMeter struct {
+	lock   *sync.Mutex
+	reader *sml.Reader
+}
+
+// newSMLMeter returns the meter for sml:///dev/ttyUSB0?baud=9600.
+func newSMLMeter(u *url.URL) (EnergyMeter, error) {
+	if u.Path == "" {
+		return nil, fmt.Errorf("missing serial device in meter address")
+	}
+	port, err := openSerial(u, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return smlMeter{lock: new(sync.Mutex), reader: sml.NewReader(port)}, nil
+}
+
+// Read waits for the next SML file from the meter.
+// PhaseCount is zero, only the total power is read.
+func (m smlMeter) PhaseCount() (int, bool) {
+	return 0, true
+}
+
+func (m smlMeter) Read(ctx context.Context) (Measurement, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for {
+		if err := ctx.Err(); err != nil {
+			return Measurement{}, err
+		}
+		messages, err := m.reader.ReadFile()
+		if errors.Is(err, sml.ErrChecksum) {
+			slog.Debug("skip corrupted sml file")
+			continue
+		}
+		if err != nil {
+			return Measurement{}, fmt.Errorf("failed to read from meter: %w", err)
+		}
+		readings, err := sml.Parse(messages)
+		if err != nil {
+			return Measurement{}, err
+		}
+		for _, r := range readings {
+			if r.OBIS == sml.OBISPower {
+				return Measurement{Total: ConsumptionPositive(r.Value)}, nil
+			}
+		}
+		return Measurement{}, fmt.Errorf("meter does not send the current power (%v), it may need to be "+
+			"unlocked with the PIN", sml.OBISPower)
+	}
+}
+
+// Subscribe provides every file sent by the meter.
+func (m smlMeter) Subscribe(ctx context.Context, fn func(Measurement)) error {
+	for {
+		measurement, err := m.Read(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fn(measurement)
+	}
+}
+
+// p1Meter implements PushMeter for the DSMR telegrams of the P1 port, connected by serial or by a
+// P1-to-network bridge. The connection is reopened after errors.
+type p1Meter struct {
+	open func() (io.ReadCloser, error)
+
+	lock   sync.Mutex
+	conn   io.ReadCloser
+	reader *p1.Reader
+}
+
+// p1ReadTimeout is longer than the telegram interval of DSMR 4 (10s).
+const p1ReadTimeout = 15 * time.Second
+
+// newP1Meter returns the meter for p1:///dev/ttyUSB0?baud=115200 or p1-tcp://host:port.
+func newP1Meter(u *url.URL) (EnergyMeter, error) {
+	switch {
+	case u.Scheme == "p1" && u.Path != "":
+		// DSMR 4 and 5 use 115200 8N1, older versions 9600 7E1.
+		q := u.Query()
+		if !q.Has("baud") {
+			q.Set("baud", "115200")
+			u.RawQuery = q.Encode()
+		}
+		return &p1Meter{open: func() (io.ReadCloser, error) {
+			return openSerial(u, p1ReadTimeout)
+		}}, nil
+	case u.Scheme == "p1-tcp" && u.Host != "":
+		return &p1Meter{open: func() (io.ReadCloser, error) {
+			return net.DialTimeout("tcp", u.Host, 5*time.Second)
+		}}, nil
+	default:
+		return nil, fmt.Errorf("missing serial device or host in meter address")
+	}
+}
+
+// Read waits for the next telegram.
+func (m *p1Meter) Read(ctx context.Context) (Measurement, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.conn == nil {
+		conn, err := m.open()
+		if err != nil {
+			return Measurement{}, err
+		}
+		m.conn = conn
+		m.reader = p1.NewReader(conn)
+	}
+	// unblock reading from network connections on cancel, serial ports time out.
+	if conn, ok := m.conn.(net.Conn); ok {
+		_ = conn.SetReadDeadline(time.Now().Add(p1ReadTimeout))
+		stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+		defer stop()
+	}
+
+	for {
+		telegram, err := m.reader.ReadTelegram()
+		if err == nil {
+			var t *p1.Telegram
+			t, err = p1.Parse(telegram)
+			if errors.Is(err, p1.ErrChecksum) {
+				slog.Debug("skip corrupted p1 telegram")
+				continue
+			}
+			if err == nil {
+				return p1Measurement(t), nil
+			}
+		}
+		_ = m.conn.Close()
+		m.conn = nil
+		return Measurement{}, fmt.Errorf("failed to read from meter: %w", err)
+	}
+}
+
+// Subscribe provides every telegram sent by the meter.
+func (m *p1Meter) Subscribe(ctx context.Context, fn func(Measurement)) error {
+	for {
+		measurement, err := m.Read(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fn(measurement)
+	}
+}
+
+func p1Measurement(t *p1.Telegram) Measurement {
+	m := Measurement{Total: ConsumptionPositive(t.Power())}
+	for _, p := range t.Phases {
+		m.Phases = append(m.Phases, PhaseMeasurement{
+			Power:         ConsumptionPositive(p.Power()),
+			Voltage:       p.Voltage,
+			Current:       p.Current,
+			ApparentPower: p.Voltage * p.Current,
+		})
+	}
+	return m
+}
+
+// openSerial opens the serial device in the path of u with baud, databits and parity from the query,
+// 9600 8N1 by default.
+func openSerial(u *url.URL, timeout time.Duration) (serial.Port, error) {
+	baud, err := queryInts(u, "baud")
+	if err != nil {
+		return nil, err
+	}
+	dataBits, err := queryInts(u, "databits")
+	if err != nil {
+		return nil, err
+	}
+	config := serial.Config{
+		Address:  u.Path,
+		BaudRate: 9600,
+		DataBits: 8,
+		Parity:   "N",
+		StopBits: 1,
+		Timeout:  timeout,
+	}
+	if len(baud) == 1 {
+		config.BaudRate = baud[0]
+	}
+	if len(dataBits) == 1 {
+		config.DataBits = dataBits[0]
+	}
+	if parity := u.Query().Get("parity"); parity != "" {
+		config.Parity = parity
+	}
+	port, err := serial.Open(&config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open serial device: %w", err)
+	}
+	return port, nil
+}
+
+// shellyGen2Client returns the http.Client for Gen2 devices, authenticating if a password is configured
+// by -shellyPasswordFile or the environment.
+func shellyGen2Client() (*http.Client, error) {
+	password, err := shelly.LoadPassword(*flagShellyPasswordFile)
+	if err != nil {
+		return nil, err
+	}
+	opts := shellyClientOptions()
+	opts.Password = password
+	return shelly.NewClient(opts), nil
+}
+
+// shellyClientOptions returns the http.Client options from -shellyTimeout and -shellyIdleTimeout.
+func shellyClientOptions() shelly.ClientOptions {
+	return shelly.ClientOptions{Timeout: *flagShellyTimeout, IdleTimeout: *flagShellyIdleTimeout}
+}
+
+// queryInts parses the comma separated list of integers in the query parameter key of u, the parameter
+// may be repeated.
+func queryInts(u *url.URL, key string) ([]int, error) {
+	value := strings.Join(u.Query()[key], ",")
+	if value == "" {
+		return nil, nil
+	}
+	var ints []int
+	for _, v := range strings.Split(value, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %v: %w", key, err)
+		}
+		ints = append(ints, i)
+	}
+	return ints, nil
+}
@@ -1,25 +1,85 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"log/slog"
 	"math"
 	"math/rand/v2"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/bsm/openmetrics"
 	"github.com/yvesf/ve-ctrl-tool/pkg/ringbuf"
-	"github.com/yvesf/ve-ctrl-tool/pkg/shelly"
 )
 
-var metricShellyPower = openmetrics.DefaultRegistry().Gauge(openmetrics.Desc{
-	Name:   "ess_shelly_power",
-	Unit:   "watt",
-	Help:   "Power readings from shelly device",
-	Labels: []string{"meter"},
-})
+var (
+	metricShellyPower = openmetrics.DefaultRegistry().Gauge(openmetrics.Desc{
+		Name:   "ess_shelly_power",
+		Unit:   "watt",
+		Help:   "Power readings from shelly device",
+		Labels: []string{"meter"},
+	})
+	metricShellyPhasePower = openmetrics.DefaultRegistry().Gauge(openmetrics.Desc{
+		Name:   "ess_shelly_phase_power",
+		Unit:   "watt",
+		Help:   "Active power per phase, positive=consumption",
+		Labels: []string{"phase"},
+	})
+	metricShellyPhaseApparentPower = openmetrics.DefaultRegistry().Gauge(openmetrics.Desc{
+		Name:   "ess_shelly_phase_apparent_power",
+		Unit:   "voltampere",
+		Help:   "Apparent power per phase",
+		Labels: []string{"phase"},
+	})
+	metricShellyPhaseVoltage = openmetrics.DefaultRegistry().Gauge(openmetrics.Desc{
+		Name:   "ess_shelly_phase_voltage",
+		Unit:   "volt",
+		Help:   "Voltage per phase",
+		Labels: []string{"phase"},
+	})
+	metricShellyPhaseCurrent = openmetrics.DefaultRegistry().Gauge(openmetrics.Desc{
+		Name:   "ess_shelly_phase_current",
+		Unit:   "ampere",
+		Help:   "Current per phase",
+		Labels: []string{"phase"},
+	})
+	metricShellyPhasePowerFactor = openmetrics.DefaultRegistry().Gauge(openmetrics.Desc{
+		Name:   "ess_shelly_phase_power_factor",
+		Help:   "Power factor per phase",
+		Labels: []string{"phase"},
+	})
+	metricShellyNeutralCurrent = openmetrics.DefaultRegistry().Gauge(openmetrics.Desc{
+		Name: "ess_shelly_neutral_current",
+		Unit: "ampere",
+		Help: "Current on the neutral line",
+	})
+	metricShellyFrequency = openmetrics.DefaultRegistry().Gauge(openmetrics.Desc{
+		Name: "ess_shelly_frequency",
+		Unit: "hertz",
+		Help: "Grid frequency",
+	})
+	metricMeterReadDuration = openmetrics.DefaultRegistry().Histogram(openmetrics.Desc{
+		Name:   "ess_meter_read_duration",
+		Unit:   "seconds",
+		Help:   "Latency of polling the meter",
+		Labels: []string{"meter"},
+	}, []float64{.01, .025, .05, .1, .2, .3, .5, 1, 2, 5})
+	metricMeterPowerStdDev = openmetrics.DefaultRegistry().Gauge(openmetrics.Desc{
+		Name: "ess_meter_power_stddev",
+		Unit: "watt",
+		Help: "Standard deviation of the last 10 polled power readings",
+	})
+	metricMeterPlausible = openmetrics.DefaultRegistry().Gauge(openmetrics.Desc{
+		Name: "ess_meter_plausible",
+		Help: "1 if the last meter reading passed the plausibility checks, 0 otherwise",
+	})
+)
+
+// phaseNames are the metric labels of the phases.
+var phaseNames = []string{"L1", "L2", "L3"}
 
 // PowerFlowWatt type represent power that can flow in two directions: Production and Consumption
 // Flow is represented by positive/negative values.
@@ -44,58 +104,147 @@ func (p PowerFlowWatt) ConsumptionNegative() float64 {
 	return float64(-p)
 }
 
-// meterReader implements the EnergyMeter interface using the Shelly 3 EM.
+// meterReader periodically reads from an EnergyMeter and provides the filtered measurement.
+// Measurements of a PushMeter are provided as they arrive without averaging.
 type meterReader struct {
-	Meter           shelly.Gen2Meter
+	Meter EnergyMeter
+	// Name is set for the sources of a composite meter. It is the metric label of the power readings,
+	// phase metrics are only provided for the main meter.
+	Name string
+	// Plausibility marks stuck and implausible readings invalid.
+	Plausibility plausibility
+	// Filter returns a new filter for the total and the phase power of polled readings, the mean of the last
+	// 5 readings if nil.
+	Filter func() meterFilter
+	// Clock is the systemClock if nil.
+	Clock clock
+
 	lock            sync.Mutex
-	lastMeasurement PowerFlowWatt
+	lastMeasurement Measurement
 	time            time.Time
+	implausible     error
+	updated         chan struct{}
 }
 
-// Run blocks until context is concelled or error occurs.
+// Run blocks until context is concelled.
+// A PushMeter is subscribed, polling is used as fallback while the subscription fails.
 func (m *meterReader) Run(ctx context.Context) error {
+	const resubscribeInterval = 30 * time.Second
+
+	push, ok := m.Meter.(PushMeter)
+	if !ok {
+		return m.poll(ctx)
+	}
+	for {
+		err := push.Subscribe(ctx, m.pushed)
+		if ctx.Err() != nil {
+			return nil
+		}
+		slog.Error("meter subscription failed, polling", slog.Duration("resubscribe", resubscribeInterval),
+			slog.Any("err", err))
+
+		pollCtx, cancel := context.WithTimeout(ctx, resubscribeInterval)
+		err = m.poll(pollCtx)
+		cancel()
+		if err != nil || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// pushed stores a measurement of a PushMeter.
+func (m *meterReader) pushed(value Measurement) {
+	m.updateMetrics("total", value)
+
+	at := value.Time
+	if at.IsZero() {
+		at = m.clock().Now()
+	}
+	if !m.plausible(value, at) {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.time = at
+	m.lastMeasurement = value
+	m.notify()
+}
+
+// poll reads the meter periodically until ctx is cancelled. Failed reads are retried indefinitely with a
+// backoff of up to backoffMax, the filter starts over after a failed read.
+func (m *meterReader) poll(ctx context.Context) error {
 	const (
 		shellyReadInterval = time.Millisecond * 800
 		backoffStart       = shellyReadInterval
 		backoffMax         = 50 * shellyReadInterval
 	)
 
-	t := time.NewTimer(0)
+	clock := m.clock()
+	t := clock.NewTimer(0)
 	defer slog.Debug("meterReader go-routine done")
 	defer t.Stop()
 
-	buf := ringbuf.NewRingbuf(5)
+	newFilter := m.Filter
+	if newFilter == nil {
+		newFilter = func() meterFilter {
+			return ringbufFilter{buf: ringbuf.NewRingbuf(5), value: (*ringbuf.Ringbuf).Mean}
+		}
+	}
+	filter := newFilter()
+	var phaseFilters []meterFilter
+	stats := ringbuf.NewRingbuf(10)
 	retry := 0
 
 	for {
 		select {
-		case <-t.C:
-			value, err := m.Meter.Read()
+		case <-t.C():
+			start := clock.Now()
+			value, err := m.Meter.Read(ctx)
+			metricMeterReadDuration.With(cmp.Or(m.Name, "main")).Observe(clock.Now().Sub(start).Seconds())
 			if err != nil {
 				retry++
 				m.lock.Lock()
 				m.time = time.Time{} // set invalid
+				m.notify()
 				m.lock.Unlock()
+				// the readings before the failure are not mixed with the readings after the backoff.
+				filter, phaseFilters = newFilter(), nil
 
 				wait := time.Duration((1.0+rand.Float64())* // random 1..2
 					float64(backoffStart.Milliseconds())*
-					math.Pow(2, float64(retry))) * time.Millisecond
-				if wait >= backoffMax {
-					return fmt.Errorf("meterReader out of retries: %w", err)
-				}
-				slog.Error("failed to read from shelly, retry", slog.Duration("wait", wait), slog.Any("err", err))
+					math.Pow(2, float64(min(retry, 10)))) * time.Millisecond
+				wait = min(wait, backoffMax)
+				slog.Error("failed to read from meter, retry", slog.Duration("wait", wait), slog.Any("err", err))
 				t.Reset(wait)
 				continue
 			}
 			retry = 0
+			now := clock.Now()
+			if !m.plausible(value, now) {
+				t.Reset(shellyReadInterval)
+				continue
+			}
 
-			buf.Add(value.TotalPower())
-			mean := buf.Mean()
-			metricShellyPower.With("totalMean").Set(mean)
+			filter.Add(value.Total.ConsumptionPositive(), now)
+			if m.Name == "" {
+				stats.Add(value.Total.ConsumptionPositive())
+				metricMeterPowerStdDev.With().Set(stats.StdDev())
+			}
+			value.Total = ConsumptionPositive(filter.Value(now))
+			value.Phases = slices.Clone(value.Phases)
+			for i := range value.Phases {
+				if i == len(phaseFilters) {
+					phaseFilters = append(phaseFilters, newFilter())
+				}
+				phaseFilters[i].Add(value.Phases[i].Power.ConsumptionPositive(), now)
+				value.Phases[i].Power = ConsumptionPositive(phaseFilters[i].Value(now))
+			}
+			m.updateMetrics("totalMean", value)
 
 			m.lock.Lock()
-			m.time = time.Now()
-			m.lastMeasurement = ConsumptionPositive(mean)
+			m.time = now
+			m.lastMeasurement = value
+			m.notify()
 			m.lock.Unlock()
 
 			t.Reset(shellyReadInterval)
@@ -105,10 +254,116 @@ func (m *meterReader) Run(ctx context.Context) error {
 	}
 }
 
+// plausible checks the raw reading value. Implausible readings set the measurement invalid.
+func (m *meterReader) plausible(value Measurement, at time.Time) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	err := m.Plausibility.check(value.Total.ConsumptionPositive(), at)
+	if err != nil {
+		if m.implausible == nil {
+			slog.Warn("implausible meter reading", slog.String("meter", m.Name), slog.Any("err", err))
+		}
+		m.time = time.Time{} // set invalid
+		m.notify()
+	} else if m.implausible != nil {
+		slog.Info("meter readings plausible again", slog.String("meter", m.Name))
+	}
+	m.implausible = err
+	if m.Name == "" {
+		plausible := 1.0
+		if err != nil {
+			plausible = 0
+		}
+		metricMeterPlausible.With().Set(plausible)
+	}
+	return err == nil
+}
+
+// SetInverterPower updates the inverter power [Watt] used for the plausibility checks, also of the sources of
+// a composite meter.
+func (m *meterReader) SetInverterPower(watt float64) {
+	if c, ok := m.Meter.(compositeMeter); ok {
+		c.SetInverterPower(watt)
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.Plausibility.inverterPower = watt
+}
+
 // LastMeasurement returns the last known power measurement. If time is Zero then value is invalid.
 // The "Run" function needs to run within a goroutine to update the value returned here.
 func (m *meterReader) LastMeasurement() (value PowerFlowWatt, time time.Time) {
+	last, time := m.last()
+	return last.Total, time
+}
+
+// LastPhases returns the per phase readings of the last measurement, the power is filtered like the total.
+// Like LastMeasurement the values are invalid if time is Zero.
+func (m *meterReader) LastPhases() (phases []PhaseMeasurement, time time.Time) {
+	last, time := m.last()
+	return last.Phases, time
+}
+
+func (m *meterReader) clock() clock {
+	if m.Clock == nil {
+		return systemClock{}
+	}
+	return m.Clock
+}
+
+// Updated returns a channel receiving a value after the last measurement changed or became invalid.
+// Changes are coalesced until the value is received, so there should be only one receiver.
+func (m *meterReader) Updated() <-chan struct{} {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.updated == nil {
+		m.updated = make(chan struct{}, 1)
+	}
+	return m.updated
+}
+
+// notify signals Updated, the lock must be held.
+func (m *meterReader) notify() {
+	if m.updated == nil {
+		m.updated = make(chan struct{}, 1)
+	}
+	select {
+	case m.updated <- struct{}{}:
+	default:
+	}
+}
+
+func (m *meterReader) last() (Measurement, time.Time) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	return m.lastMeasurement, m.time
 }
+
+// updateMetrics sets the total power with label, or with the Name of a composite meter source.
+func (m *meterReader) updateMetrics(label string, value Measurement) {
+	if m.Name != "" {
+		metricShellyPower.With(m.Name).Set(value.Total.ConsumptionPositive())
+		return
+	}
+	metricShellyPower.With(label).Set(value.Total.ConsumptionPositive())
+	updatePhaseMetrics(value)
+}
+
+func updatePhaseMetrics(m Measurement) {
+	for i, p := range m.Phases {
+		if i >= len(phaseNames) {
+			break
+		}
+		metricShellyPhasePower.With(phaseNames[i]).Set(p.Power.ConsumptionPositive())
+		metricShellyPhaseApparentPower.With(phaseNames[i]).Set(p.ApparentPower)
+		metricShellyPhaseVoltage.With(phaseNames[i]).Set(p.Voltage)
+		metricShellyPhaseCurrent.With(phaseNames[i]).Set(p.Current)
+		metricShellyPhasePowerFactor.With(phaseNames[i]).Set(p.PowerFactor)
+	}
+	if m.NeutralCurrent != nil {
+		metricShellyNeutralCurrent.With().Set(*m.NeutralCurrent)
+	}
+	if m.Frequency != 0 {
+		metricShellyFrequency.With().Set(m.Frequency)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+// pushMeterMock pushes push and then fails or blocks, Read returns read.
+type pushMeterMock struct {
+	push, read PowerFlowWatt
+	fail       bool
+}
+
+func (p pushMeterMock) Read(context.Context) (Measurement, error) {
+	return Measurement{Total: p.read}, nil
+}
+
+func (p pushMeterMock) Subscribe(ctx context.Context, fn func(Measurement)) error {
+	fn(Measurement{Total: p.push})
+	if p.fail {
+		return errors.New("connection lost")
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// pollMeterMock returns the results sent to the channel from Read.
+type pollMeterMock chan pollResult
+
+type pollResult struct {
+	measurement Measurement
+	err         error
+}
+
+func (p pollMeterMock) Read(ctx context.Context) (Measurement, error) {
+	select {
+	case r := <-p:
+		return r.measurement, r.err
+	case <-ctx.Done():
+		return Measurement{}, ctx.Err()
+	}
+}
+
+// startPolling runs a meterReader for meter on a virtual clock.
+func startPolling(t *testing.T, meter pollMeterMock) (*meterReader, *fakeClock) {
+	t.Helper()
+	clock := newFakeClock()
+	m := &meterReader{Meter: meter, Clock: clock}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- m.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		be.NilErr(t, <-done)
+	})
+	return m, clock
+}
+
+// waitForUpdate waits until m notifies about a changed measurement.
+func waitForUpdate(t *testing.T, m *meterReader) {
+	t.Helper()
+	select {
+	case <-m.Updated():
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update")
+	}
+}
+
+// waitForMeasurement waits until m provides the measurement value.
+func waitForMeasurement(t *testing.T, m *meterReader, value PowerFlowWatt) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if v, at := m.LastMeasurement(); !at.IsZero() && v == value {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	v, _ := m.LastMeasurement()
+	t.Fatalf("expected measurement %v, got %v", value, v)
+}
+
+func TestMeterReader_push(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		meter    pushMeterMock
+		expected PowerFlowWatt
+	}{
+		{name: "subscribed", meter: pushMeterMock{push: 100, read: 50}, expected: 100},
+		{name: "fallback to polling", meter: pushMeterMock{push: 100, read: 50, fail: true}, expected: 50},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			m := &meterReader{Meter: tc.meter}
+			done := make(chan error)
+			go func() { done <- m.Run(ctx) }()
+
+			waitForMeasurement(t, m, tc.expected)
+			cancel()
+			be.NilErr(t, <-done)
+		})
+	}
+}
+
+func TestMeterReader_poll(t *testing.T) {
+	meter := make(pollMeterMock)
+	m, clock := startPolling(t, meter)
+
+	// the total and the phases are filtered, the default is the mean of the last 5 readings
+	meter <- pollResult{measurement: Measurement{Total: 300, Phases: []PhaseMeasurement{{Power: 100}, {Power: 200}}}}
+	waitForUpdate(t, m)
+	be.Equal(t, 800*time.Millisecond, clock.advanceToTimer(t))
+	meter <- pollResult{measurement: Measurement{Total: 500, Phases: []PhaseMeasurement{{Power: 300}, {Power: 200}}}}
+	waitForUpdate(t, m)
+	last, at := m.last()
+	be.Equal(t, clock.Now(), at)
+	be.Equal(t, PowerFlowWatt(400), last.Total)
+	be.AllEqual(t, []PhaseMeasurement{{Power: 200}, {Power: 200}}, last.Phases)
+}
+
+func TestMeterReader_pollRetry(t *testing.T) {
+	meter := make(pollMeterMock)
+	m, clock := startPolling(t, meter)
+	meter <- pollResult{measurement: Measurement{Total: 100}}
+	waitForUpdate(t, m)
+
+	// failed reads are retried indefinitely with a backoff of up to 40s.
+	for range 20 {
+		be.True(t, clock.advanceToTimer(t) <= 40*time.Second)
+		meter <- pollResult{err: errors.New("timeout")}
+		waitForUpdate(t, m)
+		_, at := m.last()
+		be.True(t, at.IsZero())
+	}
+	be.Equal(t, 40*time.Second, clock.advanceToTimer(t))
+
+	// the meter recovers, the filter does not contain the reading before the failures.
+	meter <- pollResult{measurement: Measurement{Total: 300}}
+	waitForUpdate(t, m)
+	v, at := m.LastMeasurement()
+	be.Equal(t, clock.Now(), at)
+	be.Equal(t, PowerFlowWatt(300), v)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+
+	"github.com/yvesf/ve-ctrl-tool/internal/mqtt"
+)
+
+// startBroker serves an in-process broker and returns its address.
+func startBroker(t *testing.T) (*mqtt.Broker, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	be.NilErr(t, err)
+	b := mqtt.NewBroker()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- b.Serve(ctx, ln) }()
+	t.Cleanup(func() {
+		cancel()
+		be.NilErr(t, <-done)
+	})
+	return b, ln.Addr().String()
+}
+
+func TestMQTTMeter(t *testing.T) {
+	b, addr := startBroker(t)
+	b.Publish(mqtt.Message{
+		Topic:   "tele/tasmota/SENSOR",
+		Payload: []byte(`{"Time":"2024-01-01T00:00:00","ENERGY":{"Power":-120}}`),
+		Retain:  true,
+	})
+
+	m, err := newEnergyMeter("mqtt://" + addr + "/tele/tasmota/SENSOR?path=ENERGY.Power")
+	be.NilErr(t, err)
+	_, err = m.Read(context.Background())
+	be.Nonzero(t, err) // push only
+
+	ctx, cancel := context.WithCancel(context.Background())
+	values := make(chan Measurement, 10)
+	done := make(chan error)
+	go func() {
+		done <- m.(PushMeter).Subscribe(ctx, func(m Measurement) { values <- m })
+	}()
+	be.Equal(t, PowerFlowWatt(-120), receiveMeasurement(t, values).Total)
+	b.Publish(mqtt.Message{Topic: "tele/tasmota/SENSOR", Payload: []byte(`{"ENERGY":{}}`)})
+	b.Publish(mqtt.Message{Topic: "tele/tasmota/SENSOR", Payload: []byte(`{"ENERGY":{"Power":350}}`)})
+	be.Equal(t, PowerFlowWatt(350), receiveMeasurement(t, values).Total)
+	cancel()
+	be.NilErr(t, <-done)
+}
+
+func TestMQTTMeter_reconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	be.NilErr(t, err)
+	addr := ln.Addr().String()
+	be.NilErr(t, ln.Close())
+
+	// the subscription does not return while the broker is unavailable.
+	m, err := newEnergyMeter("mqtt://" + addr + "/tele/tasmota/SENSOR")
+	be.NilErr(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	be.NilErr(t, m.(PushMeter).Subscribe(ctx, func(Measurement) { t.Error("unexpected measurement") }))
+	be.Nonzero(t, ctx.Err())
+}
+
+func receiveMeasurement(t *testing.T, values chan Measurement) Measurement {
+	t.Helper()
+	select {
+	case m := <-values:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no measurement received")
+		return Measurement{}
+	}
+}
+
+func TestJSONPathValue(t *testing.T) {
+	for _, tc := range []struct {
+		payload, path string
+		value         float64
+		fails         bool
+	}{
+		{payload: `-12.5`, value: -12.5},
+		{payload: `"230"`, value: 230},
+		{payload: `{"ENERGY":{"Power":100}}`, path: "ENERGY.Power", value: 100},
+		{payload: `{"SML":{"Power":[1,2,3]}}`, path: "SML.Power.2", value: 3},
+		{payload: `{"SML":{"Power":[1]}}`, path: "SML.Power.1", fails: true},
+		{payload: `{"ENERGY":{"Power":100}}`, path: "ENERGY.Current", fails: true},
+		{payload: `{"ENERGY":{"Power":"off"}}`, path: "ENERGY.Power", fails: true},
+		{payload: `{"ENERGY":{"Power":100}}`, fails: true},
+		{payload: `invalid`, fails: true},
+	} {
+		value, err := jsonPathValue([]byte(tc.payload), tc.path)
+		be.Equal(t, tc.fails, err != nil)
+		be.Equal(t, tc.value, value)
+	}
+}
+
+func TestMQTTInterface(t *testing.T) {
+	_, addr := startBroker(t)
+	limits := controlSettings{MaxCharge: 250, MaxInverter: 60}
+	ctrl := newControl(limits)
+	ctrl.updateState(func(s *controlState) { s.Setpoint = -100 })
+	remote, err := newMQTTInterface("mqtt://"+addr+"/ess", ctrl, limits)
+	be.NilErr(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go remote.Run(ctx)
+
+	states := make(chan map[string]any, 10)
+	client, err := mqtt.Dial(ctx, mqtt.Options{
+		Addr:     addr,
+		ClientID: t.Name(),
+		Handler: func(m mqtt.Message) {
+			var state map[string]any
+			_ = json.Unmarshal(m.Payload, &state)
+			states <- state
+		},
+	})
+	be.NilErr(t, err)
+	defer client.Close()
+	be.NilErr(t, client.Subscribe(ctx, "ess/state"))
+
+	// waitForState returns the first state matching accept.
+	waitForState := func(accept func(map[string]any) bool) map[string]any {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case state := <-states:
+				if accept(state) {
+					return state
+				}
+			case <-timeout:
+				t.Fatal("expected state not published")
+				return nil
+			}
+		}
+	}
+
+	state := waitForState(func(map[string]any) bool { return true })
+	be.Equal(t, -100.0, state["setpoint"])
+	be.Equal(t, 250.0, state["maxCharge"])
+
+	be.NilErr(t, client.Publish(mqtt.Message{Topic: "ess/set/maxCharge", Payload: []byte("100")}))
+	waitForState(func(s map[string]any) bool { return s["maxCharge"] == 100.0 })
+
+	// values above the limits of the flags are rejected
+	be.NilErr(t, client.Publish(mqtt.Message{Topic: "ess/set/maxInverter", Payload: []byte("1000")}))
+	be.NilErr(t, client.Publish(mqtt.Message{Topic: "ess/set/target", Payload: []byte("-50")}))
+	be.NilErr(t, client.Publish(mqtt.Message{Topic: "ess/set/mode", Payload: []byte("L1")}))
+	be.NilErr(t, client.Publish(mqtt.Message{Topic: "ess/set/kp", Payload: []byte("-1")}))
+	be.NilErr(t, client.Publish(mqtt.Message{Topic: "ess/set/ki", Payload: []byte("0.05")}))
+	be.NilErr(t, client.Publish(mqtt.Message{Topic: "ess/set/pause", Payload: []byte("true")}))
+	waitForState(func(s map[string]any) bool { return s["paused"] == true })
+	be.Equal(t, controlSettings{Paused: true, MaxCharge: 100, MaxInverter: 60, Target: -50, Mode: regulateL1, Ki: 0.05},
+		ctrl.Settings())
+}
@@ -1,10 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"math"
 	"time"
 
 	"github.com/bsm/openmetrics"
-	"github.com/felixge/pidctrl"
 )
 
 var (
@@ -13,6 +14,11 @@ var (
 		Unit: "watt",
 		Help: "The current setpoint calculated by the PID controller",
 	})
+	metricControlIntegral = openmetrics.DefaultRegistry().Gauge(openmetrics.Desc{
+		Name: "ess_pid_integral",
+		Unit: "watt",
+		Help: "The integral term of the PID controller",
+	})
 	metricControlPIDMin = openmetrics.DefaultRegistry().Gauge(openmetrics.Desc{
 		Name: "ess_pid_output_min",
 		Unit: "watt",
@@ -25,24 +31,81 @@ var (
 	})
 )
 
-type PIDWithMetrics struct {
-	*pidctrl.PIDController
+// PIDController regulates the input value to zero.
+// The integral term is kept in the unit of the output, so changing Ki does not change the output. It only
+// integrates while the output is not saturated in the direction of the error (anti-windup).
+type PIDController struct {
+	kp, ki, kd     float64
+	outMin, outMax float64
+
+	integral  float64
+	prevValue float64
+	// started is false until the first update, the derivative term needs a previous value.
+	started bool
+}
+
+func NewPIDController(kp, ki, kd float64) *PIDController {
+	return &PIDController{kp: kp, ki: ki, kd: kd}
 }
 
-func NewPIDWithMetrics(p, i, d float64) PIDWithMetrics {
-	return PIDWithMetrics{
-		PIDController: pidctrl.NewPIDController(p, i, d),
+// SetGains changes the gains. The integral term is adjusted for the change of the proportional term, so the
+// output continues from the last value (bumpless transfer).
+func (c *PIDController) SetGains(kp, ki, kd float64) {
+	if c.started {
+		c.integral = c.clamp(c.integral + (c.kp-kp)*-c.prevValue)
 	}
+	c.kp, c.ki, c.kd = kp, ki, kd
 }
 
-func (p PIDWithMetrics) SetOutputLimits(valueMin, valueMax float64) {
-	p.PIDController.SetOutputLimits(valueMin, valueMax)
+// SetOutputLimits changes the limits of the output, the integral term is limited to the new range.
+func (c *PIDController) SetOutputLimits(valueMin, valueMax float64) {
+	c.outMin, c.outMax = valueMin, valueMax
+	c.integral = c.clamp(c.integral)
 	metricControlPIDMin.With().Set(valueMin)
 	metricControlPIDMax.With().Set(valueMax)
 }
 
-func (p PIDWithMetrics) UpdateDuration(value float64, duration time.Duration) float64 {
-	out := p.PIDController.UpdateDuration(value, duration)
+// Track sets the integral term to output while the output is not controlled by the PID controller, e.g. for
+// the fallback setpoint. The next update continues from output.
+func (c *PIDController) Track(output float64) {
+	c.integral = c.clamp(output)
+	c.started = false
+	metricControlIntegral.With().Set(c.integral)
+}
+
+// UpdateDuration returns the output for value measured duration after the last value.
+func (c *PIDController) UpdateDuration(value float64, duration time.Duration) float64 {
+	var (
+		dt  = duration.Seconds()
+		err = -value
+		d   float64
+	)
+	if c.started && dt > 0 {
+		d = -c.kd * (value - c.prevValue) / dt
+	}
+	c.prevValue, c.started = value, true
+
+	p := c.kp * err
+	integral := c.clamp(c.integral + c.ki*err*dt)
+	out := p + integral + d
+	if !(out > c.outMax && err > 0) && !(out < c.outMin && err < 0) {
+		c.integral = integral
+	}
+	out = c.clamp(p + c.integral + d)
+
+	metricControlIntegral.With().Set(c.integral)
 	metricControlSetpoint.With().Set(out)
 	return out
 }
+
+func (c *PIDController) clamp(v float64) float64 {
+	return max(c.outMin, min(c.outMax, v))
+}
+
+// checkGain returns an error if the gain name is negative, infinite or NaN.
+func checkGain(name string, gain float64) error {
+	if gain < 0 || math.IsInf(gain, 0) || math.IsNaN(gain) {
+		return fmt.Errorf("%v must be a positive number", name)
+	}
+	return nil
+}
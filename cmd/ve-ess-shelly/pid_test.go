@@ -0,0 +1,52 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestPIDController(t *testing.T) {
+	c := NewPIDController(0.15, 0.1, 0)
+	c.SetOutputLimits(-250, 60)
+	be.Equal(t, -150, c.UpdateDuration(1000, 0))
+	be.Equal(t, -250, c.UpdateDuration(1000, time.Second))
+
+	// no integration while saturated
+	for range 100 {
+		be.Equal(t, -250, c.UpdateDuration(1000, time.Second))
+	}
+	be.Equal(t, -75, c.UpdateDuration(-100, time.Second)) // P=15, I=-100+10
+
+	// the output does not jump on changed gains
+	be.Equal(t, -75, c.UpdateDuration(-100, 0))
+	c.SetGains(0.3, 0.1, 0)
+	be.Equal(t, -75, c.UpdateDuration(-100, 0))
+	be.Equal(t, -65, c.UpdateDuration(-100, time.Second))
+
+	// the integral term is limited to the output range
+	c.SetOutputLimits(-50, 60)
+	be.Equal(t, -50, c.UpdateDuration(0, 0))
+
+	c.Track(40)
+	be.Equal(t, 40, c.UpdateDuration(0, 0))
+}
+
+func TestPIDController_derivative(t *testing.T) {
+	c := NewPIDController(0, 0, 1)
+	c.SetOutputLimits(-1000, 1000)
+	be.Equal(t, 0, c.UpdateDuration(100, 0)) // no previous value
+	be.Equal(t, -50, c.UpdateDuration(200, 2*time.Second))
+	c.Track(0)
+	be.Equal(t, 0, c.UpdateDuration(500, time.Second))
+}
+
+func TestCheckGain(t *testing.T) {
+	be.NilErr(t, checkGain("kp", 0))
+	be.NilErr(t, checkGain("kp", 0.15))
+	for _, gain := range []float64{-0.1, math.Inf(1), math.NaN()} {
+		be.Nonzero(t, checkGain("ki", gain))
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// plausibility detects stuck and implausible meter readings. The zero value accepts all readings.
+type plausibility struct {
+	// FrozenAfter is the duration of identical readings after which the meter is considered stuck, 0 disables.
+	FrozenAfter time.Duration
+	// MaxStep [Watt] is the maximum change between two consecutive readings, 0 disables.
+	MaxStep float64
+	// MaxPower [Watt] is the maximum absolute power flow at the meter, 0 disables. The power of the inverter
+	// flows through the meter as well and extends the range.
+	MaxPower float64
+
+	inverterPower float64
+	last          float64
+	lastAt        time.Time
+	changedAt     time.Time
+}
+
+// check returns an error if the reading value at time at is implausible.
+// Every reading is remembered for the next check, also implausible ones.
+func (p *plausibility) check(value float64, at time.Time) error {
+	previous, previousAt := p.last, p.lastAt
+	p.last, p.lastAt = value, at
+	if previousAt.IsZero() || value != previous {
+		p.changedAt = at
+	}
+
+	if p.MaxPower > 0 {
+		limit := p.MaxPower + math.Abs(p.inverterPower)
+		if math.Abs(value) > limit {
+			return fmt.Errorf("meter reading %.0fW out of range +/-%.0fW", value, limit)
+		}
+	}
+	if p.MaxStep > 0 && !previousAt.IsZero() && math.Abs(value-previous) > p.MaxStep {
+		return fmt.Errorf("meter reading jumped from %.0fW to %.0fW", previous, value)
+	}
+	if p.FrozenAfter > 0 && at.Sub(p.changedAt) > p.FrozenAfter {
+		return fmt.Errorf("meter reading frozen at %.2fW since %v", value, p.changedAt.Format(time.TimeOnly))
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestPlausibility(t *testing.T) {
+	start := time.Now()
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	t.Run(`zero value accepts all`, func(t *testing.T) {
+		var p plausibility
+		be.NilErr(t, p.check(100, at(0)))
+		be.NilErr(t, p.check(-1e6, at(1)))
+		be.NilErr(t, p.check(-1e6, at(1000)))
+	})
+	t.Run(`frozen`, func(t *testing.T) {
+		p := plausibility{FrozenAfter: time.Minute}
+		be.NilErr(t, p.check(100, at(0)))
+		be.NilErr(t, p.check(100, at(60)))
+		be.Nonzero(t, p.check(100, at(61)))
+		be.NilErr(t, p.check(101, at(62)))
+	})
+	t.Run(`max step`, func(t *testing.T) {
+		p := plausibility{MaxStep: 10000}
+		be.NilErr(t, p.check(100, at(0)))
+		be.NilErr(t, p.check(-9000, at(1)))
+		be.Nonzero(t, p.check(40000, at(2)))
+		// a persisting value is accepted with the next reading
+		be.NilErr(t, p.check(40000, at(3)))
+	})
+	t.Run(`max power with inverter power`, func(t *testing.T) {
+		p := plausibility{MaxPower: 30000}
+		be.NilErr(t, p.check(-30000, at(0)))
+		be.Nonzero(t, p.check(-31000, at(1)))
+		p.inverterPower = -2000
+		be.NilErr(t, p.check(-31000, at(2)))
+		be.Nonzero(t, p.check(33000, at(3)))
+	})
+}
+
+func TestMeterReader_plausibility(t *testing.T) {
+	m := &meterReader{Plausibility: plausibility{MaxPower: 30000}}
+	m.pushed(Measurement{Total: 100})
+	_, at := m.LastMeasurement()
+	be.False(t, at.IsZero())
+
+	m.pushed(Measurement{Total: 40000})
+	v, at := m.LastMeasurement()
+	be.True(t, at.IsZero())
+	be.Equal(t, PowerFlowWatt(100), v)
+
+	m.SetInverterPower(12000)
+	m.pushed(Measurement{Total: 40000})
+	v, at = m.LastMeasurement()
+	be.False(t, at.IsZero())
+	be.Equal(t, PowerFlowWatt(40000), v)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// regulationMode selects the power flow that is regulated to zero.
+type regulationMode string
+
+const (
+	// regulateTotal regulates the sum over all phases (net metering across phases).
+	regulateTotal regulationMode = "total"
+	// regulateL1, regulateL2 and regulateL3 regulate the phase a single phase ESS is connected to.
+	regulateL1 regulationMode = "L1"
+	regulateL2 regulationMode = "L2"
+	regulateL3 regulationMode = "L3"
+	// regulateMinImport regulates the phase with the highest consumption to zero, so no phase imports from the
+	// grid. The setpoint is assumed to be distributed evenly on the phases like with a three phase ESS.
+	regulateMinImport regulationMode = "minImport"
+)
+
+var regulationModes = []regulationMode{regulateTotal, regulateL1, regulateL2, regulateL3, regulateMinImport}
+
+// errMissingPhases is returned if the meter does not provide the phases the regulation mode needs.
+var errMissingPhases = errors.New("meter does not provide the phases of the regulation mode")
+
+func parseRegulationMode(s string) (regulationMode, error) {
+	mode := regulationMode(s)
+	if !slices.Contains(regulationModes, mode) {
+		return "", fmt.Errorf("invalid regulation mode %q, one of: %v", s, regulationModes)
+	}
+	return mode, nil
+}
+
+// checkPhases returns errMissingPhases if the mode needs more phases than phaseCount.
+func (r regulationMode) checkPhases(phaseCount int) error {
+	need := map[regulationMode]int{regulateL1: 1, regulateL2: 2, regulateL3: 3, regulateMinImport: 1}[r]
+	if phaseCount < need {
+		return fmt.Errorf("%w: mode %v needs %d, the meter provides %d", errMissingPhases, r, need, phaseCount)
+	}
+	return nil
+}
+
+// power returns the power flow to regulate to zero from the total and the phases of a measurement.
+// The phase modes fail if the meter does not provide the phase.
+func (r regulationMode) power(total PowerFlowWatt, phases []PhaseMeasurement) (PowerFlowWatt, error) {
+	if err := r.checkPhases(len(phases)); err != nil {
+		return 0, err
+	}
+	switch r {
+	case regulateL1, regulateL2, regulateL3:
+		i := map[regulationMode]int{regulateL1: 0, regulateL2: 1, regulateL3: 2}[r]
+		return phases[i].Power, nil
+	case regulateMinImport:
+		highest := slices.MaxFunc(phases, func(a, b PhaseMeasurement) int {
+			return cmp.Compare(a.Power, b.Power)
+		})
+		return highest.Power * PowerFlowWatt(len(phases)), nil
+	default:
+		return total, nil
+	}
+}
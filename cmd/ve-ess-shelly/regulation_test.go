@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestRegulationMode(t *testing.T) {
+	phases := []PhaseMeasurement{{Power: 300}, {Power: -500}, {Power: 100}}
+	for _, tc := range []struct {
+		mode     regulationMode
+		phases   []PhaseMeasurement
+		expected PowerFlowWatt
+		fails    bool
+	}{
+		{mode: regulateTotal, phases: phases, expected: -100},
+		{mode: "", phases: phases, expected: -100},
+		{mode: regulateL1, phases: phases, expected: 300},
+		{mode: regulateL2, phases: phases, expected: -500},
+		{mode: regulateL3, phases: phases, expected: 100},
+		{mode: regulateL3, phases: phases[:1], fails: true},
+		{mode: regulateMinImport, phases: phases, expected: 900},
+		{mode: regulateMinImport, phases: []PhaseMeasurement{{Power: -100}, {Power: -50}}, expected: -100},
+		{mode: regulateMinImport, fails: true},
+		{mode: regulateTotal, expected: -100},
+	} {
+		power, err := tc.mode.power(-100, tc.phases)
+		be.Equal(t, tc.fails, errors.Is(err, errMissingPhases))
+		be.Equal(t, tc.expected, power)
+	}
+
+	be.NilErr(t, regulateL2.checkPhases(3))
+	be.True(t, errors.Is(regulateL2.checkPhases(1), errMissingPhases))
+	be.True(t, errors.Is(regulateMinImport.checkPhases(0), errMissingPhases))
+	be.NilErr(t, regulateTotal.checkPhases(0))
+
+	mode, err := parseRegulationMode("minImport")
+	be.NilErr(t, err)
+	be.Equal(t, regulateMinImport, mode)
+	_, err = parseRegulationMode("l1")
+	be.Nonzero(t, err)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+)
+
+// tuneSample is a measurement recorded by tune.
+type tuneSample struct {
+	At time.Time
+	// Value [Watt] is the input of the PID controller without offset, the regulated power flow with
+	// consumption negative.
+	Value float64
+}
+
+// fopdt is a first order plus dead time model of the response of the meter to the ESS setpoint.
+type fopdt struct {
+	// Gain is the change of the PID input per change of the setpoint, about 1 if all of the inverter power
+	// flows through the meter.
+	Gain         float64
+	DeadTime     time.Duration
+	TimeConstant time.Duration
+}
+
+// pidGains returns PI gains by the SIMC rules with the closed loop time constant equal to the dead time.
+func (p fopdt) pidGains() (kp, ki, kd float64) {
+	deadTime, timeConstant := p.DeadTime.Seconds(), p.TimeConstant.Seconds()
+	kp = timeConstant / (p.Gain * 2 * deadTime)
+	return kp, kp / min(timeConstant, 8*deadTime), 0
+}
+
+// runTune writes the setpoints 0, step and 0 again, each for duration, and identifies the response of the
+// meter on both steps. The step has to be within the limits of settings and of a setpoint. The setpoint is
+// reset to 0 when done or failed.
+func runTune(ctx context.Context, ess ESSControl, meter *meterReader, settings controlSettings, step int,
+	duration time.Duration,
+) (fopdt, error) {
+	if step == 0 || step < max(-settings.MaxCharge, math.MinInt16) || step > min(settings.MaxInverter, math.MaxInt16) {
+		return fopdt{}, fmt.Errorf("tune step %dW is zero or outside of -%dW (maxCharge) and %dW (maxInverter)",
+			step, settings.MaxCharge, settings.MaxInverter)
+	}
+	defer func() {
+		ctxSetpoint, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		err := ess.SetpointSet(ctxSetpoint, 0)
+		if err != nil {
+			slog.Error("failed to reset ESS setpoint to zero", slog.Any("err", err))
+		}
+	}()
+
+	clock := meter.clock()
+	var (
+		samples []tuneSample
+		steps   []time.Time
+	)
+	for _, setpoint := range []int16{0, int16(step), 0} {
+		steps = append(steps, clock.Now())
+		slog.Info("tune: hold setpoint", slog.Int("setpoint", int(setpoint)), slog.Duration("duration", duration))
+		var err error
+		samples, err = recordSetpoint(ctx, ess, meter, settings.Mode, setpoint, duration, samples)
+		if err != nil {
+			return fopdt{}, err
+		}
+	}
+	steps = append(steps, clock.Now())
+
+	rising, err := identifyFOPDT(samples, steps[0], steps[1], steps[2], float64(step))
+	if err != nil {
+		return fopdt{}, fmt.Errorf("step to %dW: %w", step, err)
+	}
+	falling, err := identifyFOPDT(samples, steps[1], steps[2], steps[3], -float64(step))
+	if err != nil {
+		return fopdt{}, fmt.Errorf("step back to 0W: %w", err)
+	}
+	return fopdt{
+		Gain:         (rising.Gain + falling.Gain) / 2,
+		DeadTime:     (rising.DeadTime + falling.DeadTime) / 2,
+		TimeConstant: (rising.TimeConstant + falling.TimeConstant) / 2,
+	}, nil
+}
+
+// recordSetpoint holds setpoint for duration and appends the new measurements of meter to samples.
+func recordSetpoint(ctx context.Context, ess ESSControl, meter *meterReader, mode regulationMode, setpoint int16,
+	duration time.Duration, samples []tuneSample,
+) ([]tuneSample, error) {
+	clock := meter.clock()
+	end := clock.NewTimer(duration)
+	defer end.Stop()
+	keepAlive := clock.NewTimer(0)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return samples, ctx.Err()
+		case <-end.C():
+			return samples, nil
+		case <-keepAlive.C():
+			keepAlive.Reset(setpointKeepAlive)
+			err := ess.SetpointSet(ctx, setpoint)
+			if err != nil {
+				return samples, fmt.Errorf("failed to write ESS setpoint: %w", err)
+			}
+		case <-meter.Updated():
+			power, at, err := meterPower(meter, mode, clock.Now())
+			if err != nil {
+				return samples, fmt.Errorf("meter unavailable: %w", err)
+			}
+			if len(samples) == 0 || at.After(samples[len(samples)-1].At) {
+				samples = append(samples, tuneSample{At: at, Value: power.ConsumptionNegative()})
+			}
+		}
+	}
+}
+
+// identifyFOPDT fits a fopdt to the response on a setpoint step at time at. The samples from the second
+// half of [from, at) are the initial value, the samples from the second half of [at, to) the final value.
+// The dead time and time constant are estimated from the times the response reaches 28.3% and 63.2% of the
+// change (two point method), both are at least the interval of the samples.
+func identifyFOPDT(samples []tuneSample, from, at, to time.Time, step float64) (fopdt, error) {
+	var before, after []tuneSample
+	for _, s := range samples {
+		switch {
+		case !s.At.Before(from) && s.At.Before(at):
+			before = append(before, s)
+		case !s.At.Before(at) && s.At.Before(to):
+			after = append(after, s)
+		}
+	}
+	if len(before) < 4 || len(after) < 4 {
+		return fopdt{}, errors.New("too few measurements")
+	}
+	initial := meanSampleValue(before[len(before)/2:])
+	final := meanSampleValue(after[len(after)/2:])
+	gain := (final - initial) / step
+	if gain < 0.2 {
+		return fopdt{}, fmt.Errorf("the regulated power changed by %.0fW on a %.0fW step, check the meter and -mode",
+			final-initial, step)
+	}
+
+	reached := func(fraction float64) time.Duration {
+		for _, s := range after {
+			if (s.Value-initial)/(final-initial) >= fraction {
+				return s.At.Sub(at)
+			}
+		}
+		return to.Sub(at)
+	}
+	t28, t63 := reached(0.283), reached(0.632)
+	interval := after[len(after)-1].At.Sub(after[0].At) / time.Duration(len(after)-1)
+	timeConstant := 3 * (t63 - t28) / 2
+	return fopdt{
+		Gain:         gain,
+		DeadTime:     max(t63-timeConstant, interval),
+		TimeConstant: max(timeConstant, interval),
+	}, nil
+}
+
+func meanSampleValue(samples []tuneSample) float64 {
+	var sum float64
+	for _, s := range samples {
+		sum += s.Value
+	}
+	return sum / float64(len(samples))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestIdentifyFOPDT(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := start.Add(30 * time.Second)
+	// gain 0.9, dead time 2s, time constant 4s
+	var samples []tuneSample
+	for i := range 600 {
+		s := tuneSample{At: start.Add(time.Duration(i) * 100 * time.Millisecond), Value: 500}
+		if dt := s.At.Sub(at) - 2*time.Second; dt > 0 {
+			s.Value += 0.9 * -100 * (1 - math.Exp(-dt.Seconds()/4))
+		}
+		samples = append(samples, s)
+	}
+
+	model, err := identifyFOPDT(samples, start, at, start.Add(time.Minute), -100)
+	be.NilErr(t, err)
+	be.True(t, math.Abs(model.Gain-0.9) < 0.01)
+	be.True(t, (model.DeadTime-2*time.Second).Abs() <= 200*time.Millisecond)
+	be.True(t, (model.TimeConstant-4*time.Second).Abs() <= 300*time.Millisecond)
+
+	// the meter responds in the wrong direction or does not respond
+	_, err = identifyFOPDT(samples, start, at, start.Add(time.Minute), 100)
+	be.True(t, err != nil)
+	be.In(t, "changed by -89W on a 100W step", err.Error())
+	_, err = identifyFOPDT(samples[:10], start, at, start.Add(time.Minute), -100)
+	be.True(t, err != nil)
+}
+
+func TestFOPDT_pidGains(t *testing.T) {
+	kp, ki, kd := fopdt{Gain: 1, DeadTime: 2 * time.Second, TimeConstant: 4 * time.Second}.pidGains()
+	be.Equal(t, 1.0, kp)
+	be.Equal(t, 0.25, ki)
+	be.Equal(t, 0.0, kd)
+}
+
+func TestRunTune(t *testing.T) {
+	clock := newFakeClock()
+	meter := &meterReader{Clock: clock}
+	var (
+		lock     sync.Mutex
+		setpoint int16
+	)
+	writes := make(chan int16, 10)
+	essMock := &essMock{setpointSet: func(_ context.Context, value int16) error {
+		lock.Lock()
+		setpoint = value
+		lock.Unlock()
+		writes <- value
+		return nil
+	}}
+	receive := func() int16 {
+		t.Helper()
+		select {
+		case v := <-writes:
+			return v
+		case <-time.After(5 * time.Second):
+			t.Fatal("no setpoint written")
+			return 0
+		}
+	}
+	// measure provides the consumption of 500W reduced by the setpoint every second.
+	measure := func() {
+		for range 20 {
+			lock.Lock()
+			meter.pushed(Measurement{Total: ConsumptionPositive(500 - float64(setpoint))})
+			lock.Unlock()
+			for len(meter.Updated()) > 0 {
+				runtime.Gosched()
+			}
+			clock.Advance(time.Second)
+		}
+	}
+
+	var model fopdt
+	done := make(chan error)
+	go func() {
+		var err error
+		model, err = runTune(context.Background(), essMock, meter, testSettings, -100, 20*time.Second)
+		done <- err
+	}()
+	be.Equal(t, 0, receive())
+	measure()
+	be.Equal(t, -100, receive())
+	measure()
+	be.Equal(t, 0, receive())
+	measure()
+	be.NilErr(t, <-done)
+	be.Equal(t, 0, receive()) // reset when done
+	be.Equal(t, 1.0, model.Gain)
+	be.True(t, model.DeadTime <= 2*time.Second)
+}
+
+func TestRunTune__limits(t *testing.T) {
+	essMock := &essMock{setpointSet: func(context.Context, int16) error {
+		t.Error("setpoint written")
+		return nil
+	}}
+	for _, step := range []int{0, -251, 61, 65586} { // 65586 is 50 as int16
+		_, err := runTune(context.Background(), essMock, &meterReader{}, testSettings, step, time.Minute)
+		be.True(t, err != nil)
+	}
+}
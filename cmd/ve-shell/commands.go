@@ -17,7 +17,7 @@ import (
 type c struct {
 	command string
 	args    int
-	fun     func(ctx context.Context, adapter *mk2.Adapter, args ...string) error
+	fun     func(ctx context.Context, adapter mk2.AdapterCommands, args ...string) error
 	help    string
 }
 
@@ -29,13 +29,13 @@ func init() {
 			command: "help",
 			args:    0,
 			help:    "help display this help",
-			fun:     func(context.Context, *mk2.Adapter, ...string) error { help(); return nil },
+			fun:     func(context.Context, mk2.AdapterCommands, ...string) error { help(); return nil },
 		},
 		{
 			command: "state",
 			args:    0,
 			help:    "state (CommandGetSetDeviceState)",
-			fun: func(ctx context.Context, adapter *mk2.Adapter, _ ...string) error {
+			fun: func(ctx context.Context, adapter mk2.AdapterCommands, _ ...string) error {
 				state, subState, err := adapter.CommandGetSetDeviceState(ctx, mk2.DeviceStateRequestStateInquiry)
 				if err != nil {
 					return fmt.Errorf("state command failed: %w", err)
@@ -49,8 +49,8 @@ func init() {
 			command: "reset",
 			args:    0,
 			help:    "reset requests sends \"R\" to request a device reset",
-			fun: func(ctx context.Context, adapter *mk2.Adapter, _ ...string) error {
-				_, _ = vebus.CommandR.Frame().WriteAndRead(ctx, adapter)
+			fun: func(ctx context.Context, adapter mk2.AdapterCommands, _ ...string) error {
+				_ = adapter.Reset(ctx)
 				time.Sleep(time.Second * 1)
 				println("reset finished")
 				return nil
@@ -60,7 +60,7 @@ func init() {
 			command: "set-state",
 			args:    1,
 			help:    "set-state 0|1|2|3 (CommandGetSetDeviceState)",
-			fun: func(ctx context.Context, adapter *mk2.Adapter, args ...string) error {
+			fun: func(ctx context.Context, adapter mk2.AdapterCommands, args ...string) error {
 				if len(args) != 1 {
 					return fmt.Errorf("wrong number of args")
 				}
@@ -80,7 +80,7 @@ func init() {
 			command: "read-setting",
 			args:    2,
 			help:    "read-setting <low-byte> <high-byte> (CommandReadSetting)",
-			fun: func(ctx context.Context, adapter *mk2.Adapter, args ...string) error {
+			fun: func(ctx context.Context, adapter mk2.AdapterCommands, args ...string) error {
 				if len(args) != 2 {
 					return fmt.Errorf("wrong number of args")
 				}
@@ -105,7 +105,7 @@ func init() {
 			command: "read-ram",
 			args:    1,
 			help:    "read-ram <ramid-byte (comma sep)> (CommandReadRAMVar)",
-			fun: func(ctx context.Context, adapter *mk2.Adapter, args ...string) error {
+			fun: func(ctx context.Context, adapter mk2.AdapterCommands, args ...string) error {
 				if len(args) != 1 {
 					return fmt.Errorf("wrong no of args")
 				}
@@ -136,7 +136,7 @@ func init() {
 			command: "write-ram-signed",
 			args:    2,
 			help:    "write-ram-signed <ram-id> <int16-value) (CommandWriteRAMVarData)",
-			fun: func(ctx context.Context, adapter *mk2.Adapter, args ...string) error {
+			fun: func(ctx context.Context, adapter mk2.AdapterCommands, args ...string) error {
 				if len(args) != 2 {
 					return fmt.Errorf("wrong number of args")
 				}
@@ -159,7 +159,7 @@ func init() {
 			command: `write-ram-id`,
 			args:    3,
 			help:    "write-ram-id <ram-id> <low-byte> <high-byte> (CommandWriteViaID)",
-			fun: func(ctx context.Context, adapter *mk2.Adapter, args ...string) error {
+			fun: func(ctx context.Context, adapter mk2.AdapterCommands, args ...string) error {
 				if len(args) != 3 {
 					return fmt.Errorf("wrong number of args")
 				}
@@ -186,7 +186,7 @@ func init() {
 			command: "write-setting",
 			args:    3,
 			help:    "write-setting <setting-id-uint16> <low-byte> <high-byte> (CommandWriteSettingData)",
-			fun: func(ctx context.Context, adapter *mk2.Adapter, args ...string) error {
+			fun: func(ctx context.Context, adapter mk2.AdapterCommands, args ...string) error {
 				if len(args) != 3 {
 					return fmt.Errorf("wrong number of args")
 				}
@@ -213,7 +213,7 @@ func init() {
 			command: "voltage",
 			args:    0,
 			help:    "voltage shows voltage information from ram",
-			fun: func(ctx context.Context, adapter *mk2.Adapter, _ ...string) error {
+			fun: func(ctx context.Context, adapter mk2.AdapterCommands, _ ...string) error {
 				uBat, uInverter, err := adapter.CommandReadRAMVarUnsigned16(ctx, vebus.RAMIDUBat, vebus.RAMIDUInverterRMS)
 				if err != nil {
 					return fmt.Errorf("voltage access UInverterRMS failed: %w", err)
@@ -226,7 +226,7 @@ func init() {
 			command: "set-address",
 			args:    1,
 			help:    "set-address selects the address (\"A\" command, default 0)",
-			fun: func(ctx context.Context, adapter *mk2.Adapter, args ...string) error {
+			fun: func(ctx context.Context, adapter mk2.AdapterCommands, args ...string) error {
 				addr, err := strconv.Atoi(args[0])
 				if err != nil {
 					return fmt.Errorf("parse addr failed: %w", err)
@@ -242,7 +242,7 @@ func init() {
 			command: "get-address",
 			args:    0,
 			help:    "get-address gets the current address (\"A\" command)",
-			fun: func(ctx context.Context, adapter *mk2.Adapter, _ ...string) error {
+			fun: func(ctx context.Context, adapter mk2.AdapterCommands, _ ...string) error {
 				addr, err := adapter.GetAddress(ctx)
 				if err != nil {
 					return fmt.Errorf("get-address failed: %w", err)
@@ -255,7 +255,7 @@ func init() {
 			command: "ess-static",
 			args:    1,
 			help:    "ess-static <arg> (run loop sending signed value to ESS Ram)",
-			fun: func(ctx context.Context, adapter *mk2.Adapter, args ...string) error {
+			fun: func(ctx context.Context, adapter mk2.AdapterCommands, args ...string) error {
 				setpointWatt, err := strconv.Atoi(args[0])
 				if err != nil {
 					return fmt.Errorf("parse high-byte failed: %w", err)
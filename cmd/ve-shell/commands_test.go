@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+
+	"github.com/yvesf/ve-ctrl-tool/pkg/mk2"
+	"github.com/yvesf/ve-ctrl-tool/pkg/mk2/mk2test"
+	"github.com/yvesf/ve-ctrl-tool/pkg/vebus"
+)
+
+func TestExecute(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run(`read-ram`, func(t *testing.T) {
+		fake := mk2test.NewFake()
+		fake.RAM[vebus.RAMIDUBat] = 1320
+		fake.RAM[vebus.RAMIDIBat] = 5
+		be.NilErr(t, execute(ctx, fake, []string{"read-ram", "4,5"}))
+		calls := fake.Calls()
+		be.Equal(t, 1, len(calls))
+		be.Equal(t, "CommandReadRAMVarUnsigned16[4 5]", calls[0].String())
+	})
+	t.Run(`read-ram not supported`, func(t *testing.T) {
+		fake := mk2test.NewFake()
+		err := execute(ctx, fake, []string{"read-ram", "4"})
+		be.True(t, errors.Is(err, mk2.ErrVariableNotSupported))
+	})
+	t.Run(`write-ram-signed`, func(t *testing.T) {
+		fake := mk2test.NewFake()
+		be.NilErr(t, execute(ctx, fake, []string{"write-ram-signed", "130", "-200"}))
+		be.Equal(t, 1, len(fake.CallsTo("CommandWriteRAMVarDataSigned")))
+		be.Equal(t, uint16(0xff38), fake.RAM[130])
+	})
+	t.Run(`write-setting`, func(t *testing.T) {
+		fake := mk2test.NewFake()
+		be.NilErr(t, execute(ctx, fake, []string{"write-setting", "258", "1", "2"}))
+		be.Equal(t, uint16(0x0201), fake.Settings[258])
+	})
+	t.Run(`set-address`, func(t *testing.T) {
+		fake := mk2test.NewFake()
+		be.NilErr(t, execute(ctx, fake, []string{"set-address", "3"}))
+		be.Equal(t, byte(3), fake.Address)
+	})
+	t.Run(`propagates adapter error`, func(t *testing.T) {
+		fake := mk2test.NewFake()
+		injected := errors.New("timeout")
+		fake.FailNext("CommandGetSetDeviceState", injected)
+		err := execute(ctx, fake, []string{"state"})
+		be.True(t, errors.Is(err, injected))
+		be.NilErr(t, execute(ctx, fake, []string{"state"}))
+	})
+	t.Run(`wrong number of arguments`, func(t *testing.T) {
+		fake := mk2test.NewFake()
+		be.Nonzero(t, execute(ctx, fake, []string{"write-setting", "1"}))
+		be.Equal(t, 0, len(fake.Calls()))
+	})
+	t.Run(`unknown command`, func(t *testing.T) {
+		be.Nonzero(t, execute(ctx, mk2test.NewFake(), []string{"does-not-exist"}))
+	})
+}
@@ -24,9 +24,8 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
-	adapter := cmd.CommonInit(ctx)
-	defer adapter.Wait()
-	defer adapter.Shutdown()
+	adapter, release := cmd.CommonInit(ctx)
+	defer release()
 
 	line := liner.NewLiner()
 	defer line.Close()
@@ -86,7 +85,7 @@ func main() {
 	slog.Info("start shutdown")
 }
 
-func execute(ctx context.Context, mk2 *mk2.Adapter, tokens []string) error {
+func execute(ctx context.Context, mk2 mk2.AdapterCommands, tokens []string) error {
 	for _, comm := range commands {
 		if comm.command != tokens[0] {
 			continue
@@ -0,0 +1,89 @@
+// package main implements a simulator for a Modbus TCP energy meter
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/yvesf/ve-ctrl-tool/pkg/modbusmeter"
+)
+
+var (
+	flagListenAddr = flag.String("l", "0.0.0.0:5020", "Address (host:port) to listen on")
+	flagModel      = flag.String("model", "sdm630", "Register map of the simulated meter")
+	flagUnitID     = flag.Uint("unit", 1, "Modbus unit id")
+)
+
+func main() {
+	flag.Parse()
+
+	model, ok := modbusmeter.Models[*flagModel]
+	if !ok {
+		slog.Error("unknown model", slog.String("model", *flagModel))
+		os.Exit(1)
+	}
+	server := modbusmeter.NewServer(byte(*flagUnitID))
+	update := func(totalPower float64) {
+		data := modbusmeter.Data{Power: totalPower, Frequency: 50}
+		for range model.Phases {
+			power := totalPower / float64(len(model.Phases))
+			data.Phases = append(data.Phases, modbusmeter.PhaseData{Power: power, Voltage: 230, Current: power / 230})
+		}
+		server.SetRegisters(model.Function, model.Encode(data))
+	}
+	update(0)
+
+	ctx, cancel := signal.NotifyContext(context.Background(),
+		syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGINT)
+	defer cancel()
+
+	ln, err := net.Listen("tcp", *flagListenAddr)
+	if err != nil {
+		panic(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.ServeTCP(ctx, ln); err != nil {
+			panic(err)
+		}
+	}()
+
+	reader := bufio.NewReader(os.Stdin)
+	for ctx.Err() == nil {
+		fmt.Printf("TotalPower=> ")
+		l, err := reader.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			slog.Info("shutdown")
+			cancel()
+			break
+		}
+		if err != nil {
+			slog.Error("failed to read line", slog.Any("err", err))
+			continue
+		}
+		l = strings.TrimSpace(l)
+		value, err := strconv.ParseFloat(l, 64)
+		if err != nil {
+			slog.Error("failed to parse line", slog.Any("err", err))
+			continue
+		}
+		update(value)
+	}
+
+	wg.Wait()
+}
@@ -1,4 +1,5 @@
-// package main implements a simulator for a shelly em 3
+// package main implements a simulator for a shelly em 3. The status is served by HTTP and pushed as
+// NotifyStatus on the websocket RPC channel (/rpc).
 package main
 
 import (
@@ -18,29 +19,35 @@ import (
 	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/yvesf/ve-ctrl-tool/pkg/shelly"
+	"github.com/yvesf/ve-ctrl-tool/pkg/websocket"
 )
 
 var (
 	flagListenAddr = flag.String("l", "0.0.0.0:8082", "Address (host:port) to listen on")
 	currentValue   = int64(0)
+
+	// changed is closed and replaced when currentValue changes.
+	changedLock sync.Mutex
+	changed     = make(chan struct{})
 )
 
 func main() {
 	flag.Parse()
 
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rpc", serveWebsocket)
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		err := json.NewEncoder(w).Encode(status())
+		if err != nil {
+			slog.Error("failed to encode json response", slog.Any("err", err))
+		}
+	})
 	server := http.Server{
-		Addr: *flagListenAddr,
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
-			var doc shelly.Gen2MeterData
-			doc.TotalPowerFloat = float64(atomic.LoadInt64(&currentValue))
-
-			err := json.NewEncoder(w).Encode(doc)
-			if err != nil {
-				slog.Error("failed to encode json response", slog.Any("err", err))
-			}
-		}),
+		Addr:    *flagListenAddr,
+		Handler: mux,
 	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(),
@@ -81,7 +88,94 @@ func main() {
 			continue
 		}
 		atomic.StoreInt64(&currentValue, value)
+
+		changedLock.Lock()
+		close(changed)
+		changed = make(chan struct{})
+		changedLock.Unlock()
 	}
 
 	wg.Wait()
 }
+
+func status() shelly.Gen2MeterData {
+	var doc shelly.Gen2MeterData
+	doc.TotalPowerFloat = float64(atomic.LoadInt64(&currentValue))
+	return doc
+}
+
+type rpcRequest struct {
+	ID     int    `json:"id"`
+	Src    string `json:"src"`
+	Method string `json:"method"`
+}
+
+// serveWebsocket answers EM.GetStatus requests and sends NotifyStatus to the client on every change.
+func serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		slog.Error("websocket upgrade failed", slog.Any("err", err))
+		return
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	requests := make(chan rpcRequest)
+	go func() {
+		defer close(requests)
+		for {
+			message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var req rpcRequest
+			err = json.Unmarshal(message, &req)
+			if err != nil {
+				slog.Error("failed to decode rpc request", slog.Any("err", err))
+				continue
+			}
+			select {
+			case requests <- req:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var dst string // notifications are sent after the client identified itself with a request
+	for {
+		changedLock.Lock()
+		c := changed
+		changedLock.Unlock()
+
+		var frame map[string]any
+		select {
+		case req, ok := <-requests:
+			if !ok {
+				return
+			}
+			dst = req.Src
+			frame = map[string]any{"id": req.ID, "src": "ve-sim-shelly3em", "dst": req.Src, "result": status()}
+			if req.Method != "EM.GetStatus" {
+				delete(frame, "result")
+				frame["error"] = map[string]any{"code": 404, "message": "No handler for " + req.Method}
+			}
+		case <-c:
+			if dst == "" {
+				continue
+			}
+			frame = map[string]any{"src": "ve-sim-shelly3em", "dst": dst, "method": "NotifyStatus", "params": map[string]any{
+				"ts":   float64(time.Now().UnixMilli()) / 1000,
+				"em:0": map[string]any{"id": 0, "total_act_power": atomic.LoadInt64(&currentValue)},
+			}}
+		}
+
+		message, _ := json.Marshal(frame)
+		err = conn.WriteMessage(message)
+		if err != nil {
+			slog.Error("failed to write websocket message", slog.Any("err", err))
+			return
+		}
+	}
+}
@@ -8,7 +8,7 @@
         pname = "ve-ctrl-tool";
         version = "0.0.1";
         src = ./.;
-        vendorHash = "sha256-g65fqfyVwmXT0pajkT6h2eUkSyz9RL9eVLHhl98s5Y8=";
+        vendorHash = "sha256-KESu//ZNyGSPDhQthBAtrygJo6hXMvpZFtZ7ezWazng=";
       };
     in
     flake-utils.lib.eachDefaultSystem
@@ -49,6 +49,26 @@
               type = lib.types.nullOr lib.types.int;
               default = null;
             };
+            fallbackSetpoint = lib.mkOption {
+              type = lib.types.nullOr lib.types.int;
+              default = null;
+              description = "ESS setpoint while the meter is unavailable, positive=discharge";
+            };
+            kp = lib.mkOption {
+              type = lib.types.nullOr lib.types.float;
+              default = null;
+              description = "Proportional gain of the PID controller";
+            };
+            ki = lib.mkOption {
+              type = lib.types.nullOr lib.types.float;
+              default = null;
+              description = "Integral gain of the PID controller";
+            };
+            kd = lib.mkOption {
+              type = lib.types.nullOr lib.types.float;
+              default = null;
+              description = "Derivative gain of the PID controller";
+            };
           };
           config =
             let
@@ -68,6 +88,10 @@
                       -metricsHTTP "${cfg.metricsAddress}" \
                       ${lib.optionalString (cfg.maxCharge != null) "-maxCharge ${toString cfg.maxCharge}"} \
                       ${lib.optionalString (cfg.maxInverter != null) "-maxInverter ${toString cfg.maxInverter}"} \
+                      ${lib.optionalString (cfg.fallbackSetpoint != null) "-fallbackSetpoint ${toString cfg.fallbackSetpoint}"} \
+                      ${lib.optionalString (cfg.kp != null) "-kp ${toString cfg.kp}"} \
+                      ${lib.optionalString (cfg.ki != null) "-ki ${toString cfg.ki}"} \
+                      ${lib.optionalString (cfg.kd != null) "-kd ${toString cfg.kd}"} \
                       "${cfg.shellyEM3}"
                   '';
                   LockPersonality = true;
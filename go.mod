@@ -7,7 +7,6 @@ toolchain go1.24.3
 require (
 	github.com/bsm/openmetrics v0.3.1
 	github.com/carlmjohnson/be v0.24.1
-	github.com/felixge/pidctrl v0.0.0-20160307080219-7b13bcae7243
 	github.com/goburrow/serial v0.1.0
 	github.com/mattn/go-shellwords v1.0.12
 	github.com/peterh/liner v1.2.2
@@ -0,0 +1,209 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+)
+
+// Broker is a minimal in-process broker for tests. Messages are delivered with QoS 0,
+// retained messages are kept in memory and authentication is not supported.
+type Broker struct {
+	lock     sync.Mutex
+	sessions map[*session]struct{}
+	retained map[string][]byte
+}
+
+// session is a connected client of the broker.
+type session struct {
+	conn      net.Conn
+	writeLock sync.Mutex
+	filters   []string // guarded by Broker.lock
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		sessions: make(map[*session]struct{}),
+		retained: make(map[string][]byte),
+	}
+}
+
+// Serve accepts client connections on ln until ctx is cancelled.
+func (b *Broker) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("mqtt: failed to accept connection: %w", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+			defer stop()
+			err := b.serveConn(conn)
+			if err != nil && !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				slog.Debug("mqtt client connection failed", slog.Any("err", err))
+			}
+		}()
+	}
+}
+
+// Publish delivers m to all subscribed clients as if it was published by a client.
+func (b *Broker) Publish(m Message) {
+	b.lock.Lock()
+	if m.Retain {
+		if len(m.Payload) == 0 {
+			delete(b.retained, m.Topic)
+		} else {
+			b.retained[m.Topic] = m.Payload
+		}
+	}
+	var receivers []*session
+	for s := range b.sessions {
+		if slices.ContainsFunc(s.filters, func(f string) bool { return Match(f, m.Topic) }) {
+			receivers = append(receivers, s)
+		}
+	}
+	b.lock.Unlock()
+
+	m.Retain = false // live messages are not flagged retained
+	for _, s := range receivers {
+		_ = s.write(publishPacket(m))
+	}
+}
+
+func (b *Broker) serveConn(conn net.Conn) error {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	connect, err := readPacket(r)
+	if err != nil {
+		return err
+	}
+	if connect.typ != packetConnect {
+		return errors.New("expected connect packet")
+	}
+
+	s := &session{conn: conn}
+	err = s.write(packet{typ: packetConnack, body: []byte{0, 0}})
+	if err != nil {
+		return err
+	}
+	b.lock.Lock()
+	b.sessions[s] = struct{}{}
+	b.lock.Unlock()
+	defer func() {
+		b.lock.Lock()
+		delete(b.sessions, s)
+		b.lock.Unlock()
+	}()
+
+	for {
+		p, err := readPacket(r)
+		if err != nil {
+			return err
+		}
+		switch p.typ {
+		case packetPublish:
+			m, id, err := parsePublish(p)
+			if err != nil {
+				return err
+			}
+			if id != 0 {
+				err = s.write(packet{typ: packetPuback, body: binary.BigEndian.AppendUint16(nil, id)})
+				if err != nil {
+					return err
+				}
+			}
+			b.Publish(m)
+		case packetSubscribe:
+			err = b.subscribe(s, p)
+			if err != nil {
+				return err
+			}
+		case packetUnsubscribe:
+			rd := reader{b: p.body}
+			id := rd.uint16()
+			b.lock.Lock()
+			for len(rd.b) > 0 && rd.err == nil {
+				filter := rd.string()
+				s.filters = slices.DeleteFunc(s.filters, func(f string) bool { return f == filter })
+			}
+			b.lock.Unlock()
+			err = s.write(packet{typ: packetUnsuback, body: binary.BigEndian.AppendUint16(nil, id)})
+			if err != nil {
+				return err
+			}
+		case packetPingreq:
+			err = s.write(packet{typ: packetPingresp})
+			if err != nil {
+				return err
+			}
+		case packetDisconnect:
+			return nil
+		default:
+			return fmt.Errorf("unexpected packet type %d", p.typ)
+		}
+	}
+}
+
+// subscribe adds the filters of a SUBSCRIBE packet and sends the matching retained messages.
+func (b *Broker) subscribe(s *session, p packet) error {
+	rd := reader{b: p.body}
+	id := rd.uint16()
+	var filters []string
+	for len(rd.b) > 0 && rd.err == nil {
+		filters = append(filters, rd.string())
+		rd.byte() // requested QoS, always granted 0
+	}
+	if rd.err != nil || len(filters) == 0 {
+		return errors.New("invalid subscribe packet")
+	}
+
+	b.lock.Lock()
+	s.filters = append(s.filters, filters...)
+	var retained []Message
+	for topic, payload := range b.retained {
+		if slices.ContainsFunc(filters, func(f string) bool { return Match(f, topic) }) {
+			retained = append(retained, Message{Topic: topic, Payload: payload, Retain: true})
+		}
+	}
+	b.lock.Unlock()
+
+	ack := binary.BigEndian.AppendUint16(nil, id)
+	ack = append(ack, make([]byte, len(filters))...)
+	err := s.write(packet{typ: packetSuback, body: ack})
+	if err != nil {
+		return err
+	}
+	for _, m := range retained {
+		err = s.write(publishPacket(m))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *session) write(p packet) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	_, err := s.conn.Write(p.encode())
+	return err
+}
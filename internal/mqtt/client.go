@@ -0,0 +1,258 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned after Close was called.
+var ErrClosed = errors.New("mqtt: connection closed")
+
+// Options of a client connection.
+type Options struct {
+	// Addr is the host:port of the broker.
+	Addr     string
+	ClientID string
+	Username string
+	Password string
+	// KeepAlive is the interval of pings, defaults to 30 seconds.
+	KeepAlive time.Duration
+	// Handler is called with every received message. It is called from the read goroutine and must not
+	// block.
+	Handler func(Message)
+}
+
+// Client is a connection to a broker. It is closed on the first error, the caller reconnects with Dial.
+type Client struct {
+	opts Options
+	conn net.Conn
+
+	writeLock sync.Mutex
+
+	lock     sync.Mutex
+	packetID uint16
+	pending  map[uint16]chan packet // waiting for SUBACK/UNSUBACK
+	err      error
+	done     chan struct{}
+}
+
+// Dial connects to the broker. The context is only used for connecting.
+func Dial(ctx context.Context, opts Options) (*Client, error) {
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = 30 * time.Second
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt: failed to connect: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	flags := byte(0x02) // clean session
+	body := appendString(nil, "MQTT")
+	body = append(body, 4) // protocol level 3.1.1
+	if opts.Username != "" {
+		flags |= 0x80
+	}
+	if opts.Password != "" {
+		flags |= 0x40
+	}
+	body = append(body, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(opts.KeepAlive/time.Second))
+	body = appendString(body, opts.ClientID)
+	if opts.Username != "" {
+		body = appendString(body, opts.Username)
+	}
+	if opts.Password != "" {
+		body = appendString(body, opts.Password)
+	}
+	_, err = conn.Write(packet{typ: packetConnect, body: body}.encode())
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("mqtt: failed to send connect: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	connack, err := readPacket(r)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("mqtt: failed to read connack: %w", err)
+	}
+	if connack.typ != packetConnack || len(connack.body) != 2 {
+		_ = conn.Close()
+		return nil, errors.New("mqtt: unexpected response to connect")
+	}
+	if code := connack.body[1]; code != 0 {
+		_ = conn.Close()
+		return nil, fmt.Errorf("mqtt: connection refused with code %d", code)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	c := &Client{
+		opts:    opts,
+		conn:    conn,
+		pending: make(map[uint16]chan packet),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop(r)
+	go c.keepAlive()
+	return c, nil
+}
+
+// Subscribe subscribes to the topic filters with QoS 0 and waits for the acknowledgement.
+func (c *Client) Subscribe(ctx context.Context, filters ...string) error {
+	id, ack := c.newPending()
+	body := binary.BigEndian.AppendUint16(nil, id)
+	for _, f := range filters {
+		body = appendString(body, f)
+		body = append(body, 0) // QoS 0
+	}
+	err := c.write(packet{typ: packetSubscribe, flags: 0x02, body: body})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case p := <-ack:
+		for _, code := range p.body[2:] {
+			if code == 0x80 {
+				return errors.New("mqtt: subscription rejected")
+			}
+		}
+		return nil
+	case <-c.done:
+		return c.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Publish sends a message with QoS 0.
+func (c *Client) Publish(m Message) error {
+	return c.write(publishPacket(m))
+}
+
+// Done is closed when the connection is closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the reason of the closed connection.
+func (c *Client) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
+
+// Close disconnects from the broker.
+func (c *Client) Close() error {
+	_ = c.write(packet{typ: packetDisconnect})
+	c.close(ErrClosed)
+	return nil
+}
+
+func (c *Client) close(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	_ = c.conn.Close()
+	close(c.done)
+}
+
+func (c *Client) write(p packet) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	select {
+	case <-c.done:
+		return c.Err()
+	default:
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.opts.KeepAlive))
+	_, err := c.conn.Write(p.encode())
+	if err != nil {
+		err = fmt.Errorf("mqtt: write failed: %w", err)
+		c.close(err)
+	}
+	return err
+}
+
+func (c *Client) newPending() (uint16, chan packet) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.packetID++
+	if c.packetID == 0 {
+		c.packetID = 1
+	}
+	ack := make(chan packet, 1)
+	c.pending[c.packetID] = ack
+	return c.packetID, ack
+}
+
+func (c *Client) readLoop(r *bufio.Reader) {
+	for {
+		// the broker answers pings, silence for longer than the keep alive means the connection is lost.
+		_ = c.conn.SetReadDeadline(time.Now().Add(c.opts.KeepAlive * 3 / 2))
+		p, err := readPacket(r)
+		if err != nil {
+			c.close(fmt.Errorf("mqtt: read failed: %w", err))
+			return
+		}
+
+		switch p.typ {
+		case packetPublish:
+			m, id, err := parsePublish(p)
+			if err != nil {
+				c.close(err)
+				return
+			}
+			if id != 0 { // QoS 1
+				_ = c.write(packet{typ: packetPuback, body: binary.BigEndian.AppendUint16(nil, id)})
+			}
+			if c.opts.Handler != nil {
+				c.opts.Handler(m)
+			}
+		case packetSuback, packetUnsuback:
+			if len(p.body) < 2 {
+				c.close(errors.New("mqtt: invalid acknowledgement"))
+				return
+			}
+			id := binary.BigEndian.Uint16(p.body)
+			c.lock.Lock()
+			ack, ok := c.pending[id]
+			delete(c.pending, id)
+			c.lock.Unlock()
+			if ok {
+				ack <- p
+			}
+		case packetPingresp, packetPuback:
+		default:
+			c.close(fmt.Errorf("mqtt: unexpected packet type %d", p.typ))
+			return
+		}
+	}
+}
+
+func (c *Client) keepAlive() {
+	t := time.NewTicker(c.opts.KeepAlive / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			_ = c.write(packet{typ: packetPingreq})
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+// startBroker serves a broker on a local port and returns its address.
+func startBroker(t *testing.T) (*Broker, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	be.NilErr(t, err)
+	b := NewBroker()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- b.Serve(ctx, ln) }()
+	t.Cleanup(func() {
+		cancel()
+		be.NilErr(t, <-done)
+	})
+	return b, ln.Addr().String()
+}
+
+// dial connects a client that delivers the received messages to the returned channel.
+func dial(t *testing.T, addr string) (*Client, chan Message) {
+	t.Helper()
+	messages := make(chan Message, 10)
+	c, err := Dial(context.Background(), Options{
+		Addr:     addr,
+		ClientID: t.Name(),
+		Handler:  func(m Message) { messages <- m },
+	})
+	be.NilErr(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+	return c, messages
+}
+
+func receive(t *testing.T, messages chan Message) Message {
+	t.Helper()
+	select {
+	case m := <-messages:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+		return Message{}
+	}
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run(`publish and subscribe`, func(t *testing.T) {
+		_, addr := startBroker(t)
+		subscriber, messages := dial(t, addr)
+		publisher, _ := dial(t, addr)
+
+		be.NilErr(t, subscriber.Subscribe(ctx, "tele/+/SENSOR"))
+		be.NilErr(t, publisher.Publish(Message{Topic: "tele/other/STATE", Payload: []byte("x")}))
+		be.NilErr(t, publisher.Publish(Message{Topic: "tele/tasmota/SENSOR", Payload: []byte(`{"Power":1}`)}))
+
+		m := receive(t, messages)
+		be.Equal(t, "tele/tasmota/SENSOR", m.Topic)
+		be.Equal(t, `{"Power":1}`, string(m.Payload))
+	})
+	t.Run(`retained message`, func(t *testing.T) {
+		b, addr := startBroker(t)
+		b.Publish(Message{Topic: "ess/state", Payload: []byte("on"), Retain: true})
+
+		c, messages := dial(t, addr)
+		be.NilErr(t, c.Subscribe(ctx, "ess/#"))
+		m := receive(t, messages)
+		be.Equal(t, "ess/state", m.Topic)
+		be.True(t, m.Retain)
+	})
+	t.Run(`large payload`, func(t *testing.T) {
+		_, addr := startBroker(t)
+		c, messages := dial(t, addr)
+		be.NilErr(t, c.Subscribe(ctx, "big"))
+		be.NilErr(t, c.Publish(Message{Topic: "big", Payload: make([]byte, 200000)}))
+		be.Equal(t, 200000, len(receive(t, messages).Payload))
+	})
+	t.Run(`broker stopped`, func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		be.NilErr(t, err)
+		bctx, cancel := context.WithCancel(ctx)
+		done := make(chan error)
+		go func() { done <- NewBroker().Serve(bctx, ln) }()
+
+		c, _ := dial(t, ln.Addr().String())
+		cancel()
+		be.NilErr(t, <-done)
+		<-c.Done()
+		be.Nonzero(t, c.Err())
+		be.Nonzero(t, c.Publish(Message{Topic: "a"}))
+	})
+	t.Run(`closed`, func(t *testing.T) {
+		_, addr := startBroker(t)
+		c, _ := dial(t, addr)
+		be.NilErr(t, c.Close())
+		be.True(t, errors.Is(c.Err(), ErrClosed))
+	})
+}
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"#", "a/b", true},
+		{"+/+/SENSOR", "tele/x/SENSOR", true},
+		{"a/b/c", "a/b", false},
+	} {
+		be.Equal(t, tc.match, Match(tc.filter, tc.topic))
+	}
+}
@@ -0,0 +1,177 @@
+// Package mqtt implements a minimal MQTT 3.1.1 client and a broker for tests. Only QoS 0 is used for
+// publishing and subscribing, sessions are not persisted.
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Packet types.
+const (
+	packetConnect     byte = 1
+	packetConnack     byte = 2
+	packetPublish     byte = 3
+	packetPuback      byte = 4
+	packetSubscribe   byte = 8
+	packetSuback      byte = 9
+	packetUnsubscribe byte = 10
+	packetUnsuback    byte = 11
+	packetPingreq     byte = 12
+	packetPingresp    byte = 13
+	packetDisconnect  byte = 14
+)
+
+// maxPacketSize limits the size of received packets.
+const maxPacketSize = 1 << 20
+
+// Message is a published message.
+type Message struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// packet is a MQTT control packet.
+type packet struct {
+	typ   byte
+	flags byte
+	body  []byte
+}
+
+func readPacket(r *bufio.Reader) (packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+	var length, shift int
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+		if shift > 21 {
+			return packet{}, errors.New("mqtt: invalid remaining length")
+		}
+	}
+	if length > maxPacketSize {
+		return packet{}, fmt.Errorf("mqtt: packet of %d bytes too large", length)
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return packet{}, err
+	}
+	return packet{typ: header >> 4, flags: header & 0x0f, body: body}, nil
+}
+
+// encode returns the packet with fixed header.
+func (p packet) encode() []byte {
+	b := []byte{p.typ<<4 | p.flags}
+	length := len(p.body)
+	for {
+		digit := byte(length & 0x7f)
+		length >>= 7
+		if length > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if length == 0 {
+			break
+		}
+	}
+	return append(b, p.body...)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// reader decodes the fields of a packet body.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) uint16() uint16 {
+	if len(r.b) < 2 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.b)
+	r.b = r.b[2:]
+	return v
+}
+
+func (r *reader) byte() byte {
+	if len(r.b) < 1 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *reader) string() string {
+	n := int(r.uint16())
+	if len(r.b) < n {
+		r.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	v := string(r.b[:n])
+	r.b = r.b[n:]
+	return v
+}
+
+// publishPacket returns a QoS 0 PUBLISH packet.
+func publishPacket(m Message) packet {
+	var flags byte
+	if m.Retain {
+		flags = 0x01
+	}
+	return packet{typ: packetPublish, flags: flags, body: append(appendString(nil, m.Topic), m.Payload...)}
+}
+
+// parsePublish returns the message and the packet id (0 for QoS 0) of a PUBLISH packet.
+func parsePublish(p packet) (Message, uint16, error) {
+	r := reader{b: p.body}
+	m := Message{Topic: r.string(), Retain: p.flags&0x01 != 0}
+	var id uint16
+	if qos := p.flags >> 1 & 0x03; qos > 0 {
+		id = r.uint16()
+	}
+	if r.err != nil {
+		return Message{}, 0, fmt.Errorf("mqtt: invalid publish packet: %w", r.err)
+	}
+	m.Payload = r.b
+	return m, id, nil
+}
+
+// Match reports whether topic matches the subscription filter with the wildcards + and #.
+func Match(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, f := range filterLevels {
+		if f == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if f != "+" && f != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,58 @@
+// Package broker shares one mk2.Adapter between multiple local processes.
+//
+// The Server owns the adapter and executes requests of all connected clients one after another.
+// The Client implements mk2.AdapterCommands and can be used in place of mk2.Adapter.
+// Client and Server exchange newline delimited JSON messages, one response for each request.
+package broker
+
+import (
+	"errors"
+
+	"github.com/yvesf/ve-ctrl-tool/pkg/mk2"
+)
+
+// request is sent by the client. Args are the arguments of the mk2.AdapterCommands method named by Method.
+type request struct {
+	Method string `json:"method"`
+	Args   []int  `json:"args,omitempty"`
+}
+
+// response is sent by the server after executing a request.
+type response struct {
+	Values   []int  `json:"values,omitempty"`
+	State    string `json:"state,omitempty"`
+	SubState string `json:"subState,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// ErrorCode identifies errors that callers may check with errors.Is.
+	ErrorCode string `json:"errorCode,omitempty"`
+}
+
+const (
+	errorCodeVariableNotSupported = "variable-not-supported"
+	errorCodeSettingNotSupported  = "setting-not-supported"
+)
+
+func errorResponse(err error) response {
+	resp := response{Error: err.Error()}
+	switch {
+	case errors.Is(err, mk2.ErrVariableNotSupported):
+		resp.ErrorCode = errorCodeVariableNotSupported
+	case errors.Is(err, mk2.ErrSettingNotSupported):
+		resp.ErrorCode = errorCodeSettingNotSupported
+	}
+	return resp
+}
+
+// responseError reconstructs the error of a response.
+func (r response) responseError() error {
+	switch r.ErrorCode {
+	case errorCodeVariableNotSupported:
+		return mk2.ErrVariableNotSupported
+	case errorCodeSettingNotSupported:
+		return mk2.ErrSettingNotSupported
+	}
+	if r.Error != "" {
+		return errors.New(r.Error)
+	}
+	return nil
+}
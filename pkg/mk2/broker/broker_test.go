@@ -0,0 +1,116 @@
+package broker_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+
+	"github.com/yvesf/ve-ctrl-tool/pkg/mk2"
+	"github.com/yvesf/ve-ctrl-tool/pkg/mk2/broker"
+	"github.com/yvesf/ve-ctrl-tool/pkg/mk2/mk2test"
+	"github.com/yvesf/ve-ctrl-tool/pkg/vebus"
+)
+
+func startServer(t *testing.T, fake *mk2test.Fake) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "broker.sock")
+	ln, err := net.Listen("unix", path)
+	be.NilErr(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- broker.NewServer(fake, 0).Serve(ctx, ln) }()
+	t.Cleanup(func() {
+		cancel()
+		be.NilErr(t, <-done)
+	})
+	return path
+}
+
+func dial(t *testing.T, path string) *broker.Client {
+	t.Helper()
+	c, err := broker.Dial(context.Background(), path)
+	be.NilErr(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestBroker(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run(`read and write ram`, func(t *testing.T) {
+		fake := mk2test.NewFake()
+		fake.SetRAMSigned(vebus.RAMIDIBat, -55)
+		c := dial(t, startServer(t, fake))
+
+		iBat, _, err := c.CommandReadRAMVarSigned16(ctx, vebus.RAMIDIBat, 0)
+		be.NilErr(t, err)
+		be.Equal(t, int16(-55), iBat)
+
+		be.NilErr(t, c.CommandWriteRAMVarDataSigned(ctx, 130, -200))
+		be.Equal(t, uint16(0xff38), fake.RAM[130])
+	})
+	t.Run(`device state`, func(t *testing.T) {
+		c := dial(t, startServer(t, mk2test.NewFake()))
+		state, subState, err := c.CommandGetSetDeviceState(ctx, mk2.DeviceStateRequestStateInquiry)
+		be.NilErr(t, err)
+		be.Equal(t, "charge", state)
+		be.Equal(t, "bulk", subState)
+	})
+	t.Run(`errors keep their identity`, func(t *testing.T) {
+		fake := mk2test.NewFake()
+		c := dial(t, startServer(t, fake))
+
+		_, _, err := c.CommandReadRAMVarUnsigned16(ctx, 99, 0)
+		be.True(t, errors.Is(err, mk2.ErrVariableNotSupported))
+		_, _, err = c.CommandReadSetting(ctx, 1, 0)
+		be.True(t, errors.Is(err, mk2.ErrSettingNotSupported))
+
+		fake.FailNext("CommandWriteSettingData", errors.New("write failed"))
+		err = c.CommandWriteSettingData(ctx, 1, 0, 0)
+		be.Equal(t, "write failed", err.Error())
+
+		// the connection is still usable
+		be.NilErr(t, c.CommandWriteSettingData(ctx, 1, 0, 0))
+	})
+	t.Run(`address per client`, func(t *testing.T) {
+		fake := mk2test.NewFake()
+		path := startServer(t, fake)
+		c1 := dial(t, path)
+		c2 := dial(t, path)
+
+		be.NilErr(t, c2.SetAddress(ctx, 2))
+		be.NilErr(t, c1.Reset(ctx))
+		be.NilErr(t, c2.Reset(ctx))
+		be.NilErr(t, c2.Reset(ctx))
+
+		var calls []string
+		for _, c := range fake.Calls() {
+			calls = append(calls, c.String())
+		}
+		be.AllEqual(t, []string{"SetAddress[2]", "SetAddress[0]", "Reset[]", "SetAddress[2]", "Reset[]", "Reset[]"},
+			calls)
+	})
+	t.Run(`ESS via broker`, func(t *testing.T) {
+		fake := mk2test.NewFake()
+		fake.RAM[128] = 0x0052 // ESS assistant
+		c := dial(t, startServer(t, fake))
+
+		ess, err := mk2.ESSInit(ctx, c)
+		be.NilErr(t, err)
+		be.NilErr(t, ess.SetpointSet(ctx, 100))
+		be.Equal(t, uint16(100), fake.RAM[129])
+	})
+	t.Run(`cancelled context`, func(t *testing.T) {
+		c := dial(t, startServer(t, mk2test.NewFake()))
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+		err := c.Reset(cctx)
+		be.True(t, errors.Is(err, context.Canceled))
+		be.NilErr(t, c.Reset(ctx))
+	})
+}
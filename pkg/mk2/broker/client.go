@@ -0,0 +1,180 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/yvesf/ve-ctrl-tool/pkg/mk2"
+	"github.com/yvesf/ve-ctrl-tool/pkg/vebus"
+)
+
+// Client implements mk2.AdapterCommands by forwarding all commands to a Server.
+type Client struct {
+	lock    sync.Mutex
+	conn    net.Conn
+	scanner *bufio.Scanner
+	broken  error
+}
+
+var _ mk2.AdapterCommands = &Client{}
+
+// Dial connects to the Server listening on the unix socket at path.
+func Dial(ctx context.Context, path string) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to broker: %w", err)
+	}
+	return &Client{conn: conn, scanner: bufio.NewScanner(conn)}, nil
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// call sends a request and waits for the response. If the exchange is interrupted, e.g. by cancelling ctx,
+// the connection is out of sync and all further calls fail.
+func (c *Client) call(ctx context.Context, method string, args ...int) (response, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.broken != nil {
+		return response{}, c.broken
+	}
+	if err := ctx.Err(); err != nil {
+		return response{}, err
+	}
+
+	stop := context.AfterFunc(ctx, func() { _ = c.conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	resp, err := c.exchange(request{Method: method, Args: args})
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		c.broken = fmt.Errorf("broker connection unusable after failed %v: %w", method, err)
+		_ = c.conn.Close()
+		return response{}, err
+	}
+	return resp, resp.responseError()
+}
+
+func (c *Client) exchange(req request) (response, error) {
+	err := json.NewEncoder(c.conn).Encode(req)
+	if err != nil {
+		return response{}, fmt.Errorf("failed to send request: %w", err)
+	}
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return response{}, fmt.Errorf("failed to read response: %w", err)
+		}
+		return response{}, errors.New("broker closed connection")
+	}
+	var resp response
+	err = json.Unmarshal(c.scanner.Bytes(), &resp)
+	if err != nil {
+		return response{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return resp, nil
+}
+
+func (c *Client) SetAddress(ctx context.Context, address byte) error {
+	_, err := c.call(ctx, "SetAddress", int(address))
+	return err
+}
+
+func (c *Client) GetAddress(ctx context.Context) (byte, error) {
+	resp, err := c.call(ctx, "GetAddress")
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Values) != 1 {
+		return 0, fmt.Errorf("invalid response length to GetAddress")
+	}
+	return byte(resp.Values[0]), nil
+}
+
+func (c *Client) Reset(ctx context.Context) error {
+	_, err := c.call(ctx, "Reset")
+	return err
+}
+
+func (c *Client) CommandGetSetDeviceState(ctx context.Context, setState mk2.DeviceStateRequestState,
+) (state mk2.DeviceStateResponseState, subState mk2.DeviceStateResponseSubState, err error) {
+	resp, err := c.call(ctx, "CommandGetSetDeviceState", int(setState))
+	if err != nil {
+		return "", "", err
+	}
+	return mk2.DeviceStateResponseState(resp.State), mk2.DeviceStateResponseSubState(resp.SubState), nil
+}
+
+func (c *Client) CommandReadSetting(ctx context.Context, lowSettingID, highSettingID byte,
+) (lowValue, highValue byte, err error) {
+	resp, err := c.call(ctx, "CommandReadSetting", int(lowSettingID), int(highSettingID))
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(resp.Values) != 2 {
+		return 0, 0, fmt.Errorf("invalid response length to CommandReadSetting")
+	}
+	return byte(resp.Values[0]), byte(resp.Values[1]), nil
+}
+
+func (c *Client) CommandReadRAMVar(ctx context.Context, ramID0, ramID1 byte,
+) (value0Low, value0High, value1Low, value1High byte, err error) {
+	resp, err := c.call(ctx, "CommandReadRAMVar", int(ramID0), int(ramID1))
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	if len(resp.Values) != 4 {
+		return 0, 0, 0, 0, fmt.Errorf("invalid response length to CommandReadRAMVar")
+	}
+	v := resp.Values
+	return byte(v[0]), byte(v[1]), byte(v[2]), byte(v[3]), nil
+}
+
+func (c *Client) CommandReadRAMVarUnsigned16(ctx context.Context, ramID0, ramID1 byte,
+) (value0, value1 uint16, err error) {
+	v0l, v0h, v1l, v1h, err := c.CommandReadRAMVar(ctx, ramID0, ramID1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint16(v0l) | uint16(v0h)<<8, uint16(v1l) | uint16(v1h)<<8, nil
+}
+
+func (c *Client) CommandReadRAMVarSigned16(ctx context.Context, ramID0, ramID1 byte,
+) (value0, value1 int16, err error) {
+	v0l, v0h, v1l, v1h, err := c.CommandReadRAMVar(ctx, ramID0, ramID1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return vebus.ParseSigned16Bytes(v0l, v0h), vebus.ParseSigned16Bytes(v1l, v1h), nil
+}
+
+func (c *Client) CommandWriteRAMVarDataSigned(ctx context.Context, ram uint16, value int16) error {
+	low, high := vebus.Signed16Bytes(value)
+	return c.CommandWriteRAMVarData(ctx, ram, low, high)
+}
+
+func (c *Client) CommandWriteRAMVarData(ctx context.Context, ram uint16, low, high byte) error {
+	_, err := c.call(ctx, "CommandWriteRAMVarData", int(ram), int(low), int(high))
+	return err
+}
+
+func (c *Client) CommandWriteViaID(ctx context.Context, id byte, dataLow, dataHigh byte) error {
+	_, err := c.call(ctx, "CommandWriteViaID", int(id), int(dataLow), int(dataHigh))
+	return err
+}
+
+func (c *Client) CommandWriteSettingData(ctx context.Context, setting uint16, dataLow, dataHigh byte) error {
+	_, err := c.call(ctx, "CommandWriteSettingData", int(setting), int(dataLow), int(dataHigh))
+	return err
+}
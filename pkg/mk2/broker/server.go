@@ -0,0 +1,170 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+
+	"github.com/yvesf/ve-ctrl-tool/pkg/mk2"
+)
+
+// Server executes requests of clients on Adapter.
+// Every client has its own selected VE.Bus address. The server switches the address of the
+// adapter before executing a request if the previous request was for another address.
+type Server struct {
+	adapter        mk2.AdapterCommands
+	defaultAddress byte
+
+	lock    sync.Mutex // serializes access to adapter
+	address byte       // address currently selected on adapter
+}
+
+// NewServer returns a Server for adapter. address is the VE.Bus address currently selected on adapter
+// and the initial address of each client.
+func NewServer(adapter mk2.AdapterCommands, address byte) *Server {
+	return &Server{adapter: adapter, defaultAddress: address, address: address}
+}
+
+// Serve accepts connections on ln until ctx is cancelled.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	stop := context.AfterFunc(ctx, func() { _ = ln.Close() })
+	defer stop()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveConn(ctx, conn)
+		}()
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	defer conn.Close()
+
+	slog.Info("client connected")
+	defer slog.Info("client disconnected")
+
+	address := s.defaultAddress
+	scanner := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req request
+		var resp response
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp = errorResponse(fmt.Errorf("invalid request: %w", err))
+		} else {
+			resp = s.execute(ctx, &address, req)
+		}
+		if err := enc.Encode(resp); err != nil {
+			slog.Warn("failed to send response", slog.Any("err", err))
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		slog.Warn("failed to read request", slog.Any("err", err))
+	}
+}
+
+// execute runs req on the adapter. address is the address selected by the client.
+func (s *Server) execute(ctx context.Context, address *byte, req request) response {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if req.Method == "SetAddress" {
+		if err := checkArgs(req, 1); err != nil {
+			return errorResponse(err)
+		}
+		if err := s.adapter.SetAddress(ctx, byte(req.Args[0])); err != nil {
+			return errorResponse(err)
+		}
+		s.address = byte(req.Args[0])
+		*address = s.address
+		return response{}
+	}
+
+	if s.address != *address {
+		slog.Debug("switch address for client", slog.Int("address", int(*address)))
+		if err := s.adapter.SetAddress(ctx, *address); err != nil {
+			return errorResponse(err)
+		}
+		s.address = *address
+	}
+
+	resp, err := s.dispatch(ctx, req)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return resp
+}
+
+func (s *Server) dispatch(ctx context.Context, req request) (response, error) {
+	a := s.adapter
+	switch req.Method {
+	case "GetAddress":
+		addr, err := a.GetAddress(ctx)
+		return response{Values: []int{int(addr)}}, err
+	case "Reset":
+		return response{}, a.Reset(ctx)
+	case "CommandGetSetDeviceState":
+		if err := checkArgs(req, 1); err != nil {
+			return response{}, err
+		}
+		state, subState, err := a.CommandGetSetDeviceState(ctx, mk2.DeviceStateRequestState(req.Args[0]))
+		return response{State: string(state), SubState: string(subState)}, err
+	case "CommandReadSetting":
+		if err := checkArgs(req, 2); err != nil {
+			return response{}, err
+		}
+		low, high, err := a.CommandReadSetting(ctx, byte(req.Args[0]), byte(req.Args[1]))
+		return response{Values: []int{int(low), int(high)}}, err
+	case "CommandReadRAMVar":
+		if err := checkArgs(req, 2); err != nil {
+			return response{}, err
+		}
+		v0l, v0h, v1l, v1h, err := a.CommandReadRAMVar(ctx, byte(req.Args[0]), byte(req.Args[1]))
+		return response{Values: []int{int(v0l), int(v0h), int(v1l), int(v1h)}}, err
+	case "CommandWriteRAMVarData":
+		if err := checkArgs(req, 3); err != nil {
+			return response{}, err
+		}
+		return response{}, a.CommandWriteRAMVarData(ctx, uint16(req.Args[0]), byte(req.Args[1]), byte(req.Args[2]))
+	case "CommandWriteViaID":
+		if err := checkArgs(req, 3); err != nil {
+			return response{}, err
+		}
+		return response{}, a.CommandWriteViaID(ctx, byte(req.Args[0]), byte(req.Args[1]), byte(req.Args[2]))
+	case "CommandWriteSettingData":
+		if err := checkArgs(req, 3); err != nil {
+			return response{}, err
+		}
+		return response{}, a.CommandWriteSettingData(ctx, uint16(req.Args[0]), byte(req.Args[1]), byte(req.Args[2]))
+	default:
+		return response{}, fmt.Errorf("unknown method %q", req.Method)
+	}
+}
+
+func checkArgs(req request, n int) error {
+	if len(req.Args) != n {
+		return fmt.Errorf("method %v expects %d arguments, got %d", req.Method, n, len(req.Args))
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package mk2
+
+import "os"
+
+// lockDevice is not supported on this platform, the device is not locked.
+func lockDevice(string) (*os.File, error) {
+	return nil, nil
+}
@@ -0,0 +1,58 @@
+//go:build unix
+
+package mk2
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lockDevice takes an exclusive advisory lock (flock) on the device at address.
+// The lock is held as long as the returned file is open.
+func lockDevice(address string) (*os.File, error) {
+	f, err := os.OpenFile(address, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		_ = f.Close()
+		return nil, fmt.Errorf("%w: %s is used by %s", ErrDeviceLocked, address, lockHolder(address))
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", address, err)
+	}
+	return f, nil
+}
+
+// lockHolder makes a best-effort attempt to name the process that has the device opened.
+// It relies on /proc and only sees processes of the same user or all processes if running as root.
+func lockHolder(address string) string {
+	device, err := filepath.EvalSymlinks(address)
+	if err != nil {
+		device = address
+	}
+	fdLinks, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	for _, fdLink := range fdLinks {
+		target, err := os.Readlink(fdLink)
+		if err != nil || target != device {
+			continue
+		}
+		pid := strings.Split(fdLink, "/")[2]
+		if _, err := strconv.Atoi(pid); err != nil {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join("/proc", pid, "comm"))
+		if err != nil {
+			return "pid " + pid
+		}
+		return fmt.Sprintf("pid %s (%s)", pid, strings.TrimSpace(string(comm)))
+	}
+	return "another process"
+}
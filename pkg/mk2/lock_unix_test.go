@@ -0,0 +1,65 @@
+//go:build unix
+
+package mk2
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+	"github.com/goburrow/serial"
+)
+
+func TestLockDevice(t *testing.T) {
+	device := filepath.Join(t.TempDir(), "ttyUSB0")
+	be.NilErr(t, os.WriteFile(device, nil, 0o600))
+
+	f, err := lockDevice(device)
+	be.NilErr(t, err)
+
+	_, err = lockDevice(device)
+	be.True(t, errors.Is(err, ErrDeviceLocked))
+	if _, statErr := os.Stat("/proc/self/fd"); statErr == nil {
+		be.True(t, strings.Contains(err.Error(), "pid "+strconv.Itoa(os.Getpid())))
+	}
+
+	be.NilErr(t, f.Close())
+	f, err = lockDevice(device)
+	be.NilErr(t, err)
+	be.NilErr(t, f.Close())
+}
+
+func TestIOShutdownUnlocksDevice(t *testing.T) {
+	device := filepath.Join(t.TempDir(), "ttyUSB0")
+	be.NilErr(t, os.WriteFile(device, nil, 0o600))
+
+	port := &transcriptPort{t: t, dataReady: make(chan struct{}, 1)}
+	port.readBuf.Write(broadcastV)
+	io := newIO(port, serial.Config{})
+	var err error
+	io.deviceLock, err = lockDevice(device)
+	be.NilErr(t, err)
+	be.NilErr(t, io.StartReader())
+
+	_, err = lockDevice(device)
+	be.True(t, errors.Is(err, ErrDeviceLocked))
+
+	io.Shutdown()
+	io.Wait()
+	f, err := lockDevice(device)
+	be.NilErr(t, err)
+	be.NilErr(t, f.Close())
+
+	// without reader the lock is released by Shutdown
+	io = newIO(port, serial.Config{})
+	io.deviceLock, err = lockDevice(device)
+	be.NilErr(t, err)
+	io.Shutdown()
+	f, err = lockDevice(device)
+	be.NilErr(t, err)
+	be.NilErr(t, f.Close())
+}
@@ -9,11 +9,32 @@ import (
 	"github.com/yvesf/ve-ctrl-tool/pkg/vebus"
 )
 
+// AdapterCommands is the command surface of Adapter.
+// Consumers should depend on this interface so that the adapter can be replaced, e.g. by mk2test.Fake.
+type AdapterCommands interface {
+	SetAddress(ctx context.Context, address byte) error
+	GetAddress(ctx context.Context) (byte, error)
+	Reset(ctx context.Context) error
+	CommandGetSetDeviceState(ctx context.Context, setState DeviceStateRequestState,
+	) (state DeviceStateResponseState, subState DeviceStateResponseSubState, err error)
+	CommandReadSetting(ctx context.Context, lowSettingID, highSettingID byte) (lowValue, highValue byte, err error)
+	CommandReadRAMVar(ctx context.Context, ramID0, ramID1 byte,
+	) (value0Low, value0High, value1Low, value1High byte, err error)
+	CommandReadRAMVarUnsigned16(ctx context.Context, ramID0, ramID1 byte) (value0, value1 uint16, err error)
+	CommandReadRAMVarSigned16(ctx context.Context, ramID0, ramID1 byte) (value0, value1 int16, err error)
+	CommandWriteRAMVarDataSigned(ctx context.Context, ram uint16, value int16) error
+	CommandWriteRAMVarData(ctx context.Context, ram uint16, low, high byte) error
+	CommandWriteViaID(ctx context.Context, id byte, dataLow, dataHigh byte) error
+	CommandWriteSettingData(ctx context.Context, setting uint16, dataLow, dataHigh byte) error
+}
+
 // Adapter wraps Mk2IO and adds generic functions to execute commands.
 type Adapter struct {
 	*IO
 }
 
+var _ AdapterCommands = Adapter{}
+
 func NewAdapter(address string) (*Adapter, error) {
 	reader, err := NewReader(address)
 	if err != nil {
@@ -53,6 +74,15 @@ func (m Adapter) GetAddress(ctx context.Context) (byte, error) {
 	return frame.Data[0], nil
 }
 
+// Reset sends the "R" command to request a device reset.
+func (m Adapter) Reset(ctx context.Context) error {
+	_, err := vebus.CommandR.Frame().WriteAndRead(ctx, m)
+	if err != nil {
+		return fmt.Errorf("failed to execute reset: %w", err)
+	}
+	return nil
+}
+
 type DeviceStateRequestState byte
 
 const (
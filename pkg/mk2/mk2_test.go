@@ -0,0 +1,242 @@
+package mk2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+	"github.com/goburrow/serial"
+)
+
+// broadcastV is a 'V' broadcast frame as sent periodically by the MK3 adapter. It is used to synchronize the reader.
+var broadcastV = []byte{0x07, 0xff, 'V', 0x24, 0xdb, 0x11, 0x00, 0x00, 0x94}
+
+// exchange is one step of a recorded transcript: the exact bytes written to the port and the bytes
+// the device replies with. reply may be nil if the device does not answer.
+type exchange struct {
+	write []byte
+	reply []byte
+}
+
+// transcriptPort implements serial.Port by playing back a transcript of exchanges.
+type transcriptPort struct {
+	t         *testing.T
+	lock      sync.Mutex
+	exchanges []exchange
+	readBuf   bytes.Buffer
+	dataReady chan struct{}
+}
+
+func (p *transcriptPort) Open(*serial.Config) error { return nil }
+
+func (p *transcriptPort) Close() error { return nil }
+
+func (p *transcriptPort) Read(b []byte) (int, error) {
+	p.lock.Lock()
+	if p.readBuf.Len() > 0 {
+		defer p.lock.Unlock()
+		return p.readBuf.Read(b)
+	}
+	p.lock.Unlock()
+
+	// behave like a serial port with read timeout
+	select {
+	case <-p.dataReady:
+	case <-time.After(time.Millisecond * 10):
+	}
+	return 0, nil
+}
+
+func (p *transcriptPort) Write(b []byte) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.exchanges) == 0 {
+		p.t.Errorf("unexpected write % x", b)
+		return len(b), nil
+	}
+	next := p.exchanges[0]
+	p.exchanges = p.exchanges[1:]
+	if !bytes.Equal(next.write, b) {
+		p.t.Errorf("unexpected write\n got: % x\nwant: % x", b, next.write)
+	}
+	p.readBuf.Write(next.reply)
+	select {
+	case p.dataReady <- struct{}{}:
+	default:
+	}
+	return len(b), nil
+}
+
+// newTranscriptAdapter returns an Adapter with started reader that plays back exchanges.
+// The test fails if not all exchanges have been played back at the end of the test.
+func newTranscriptAdapter(t *testing.T, exchanges ...exchange) *Adapter {
+	t.Helper()
+	port := &transcriptPort{t: t, exchanges: exchanges, dataReady: make(chan struct{}, 1)}
+	port.readBuf.Write(broadcastV)
+
+	io := newIO(port, serial.Config{})
+	be.NilErr(t, io.StartReader())
+	t.Cleanup(func() {
+		io.Shutdown()
+		io.Wait()
+		port.lock.Lock()
+		defer port.lock.Unlock()
+		if len(port.exchanges) != 0 {
+			t.Errorf("%d exchanges not played back, next: % x", len(port.exchanges), port.exchanges[0].write)
+		}
+	})
+	return &Adapter{io}
+}
+
+func TestAdapter_SetAddress(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run(`ok`, func(t *testing.T) {
+		a := newTranscriptAdapter(t, exchange{
+			write: []byte{0x04, 0xff, 'A', 0x01, 0x02, 0xb9},
+			reply: []byte{0x04, 0xff, 'A', 0x01, 0x02, 0xb9},
+		})
+		be.NilErr(t, a.SetAddress(ctx, 2))
+	})
+	t.Run(`other address selected`, func(t *testing.T) {
+		a := newTranscriptAdapter(t, exchange{
+			write: []byte{0x04, 0xff, 'A', 0x01, 0x02, 0xb9},
+			reply: []byte{0x04, 0xff, 'A', 0x01, 0x03, 0xb8},
+		})
+		be.Nonzero(t, a.SetAddress(ctx, 2))
+	})
+}
+
+func TestAdapter_CommandGetSetDeviceState(t *testing.T) {
+	a := newTranscriptAdapter(t, exchange{
+		write: []byte{0x05, 0xff, 'W', 0x0e, 0x00, 0x00, 0x97},
+		reply: []byte{0x05, 0xff, 'W', 0x94, 0x09, 0x01, 0x07},
+	})
+	state, subState, err := a.CommandGetSetDeviceState(context.Background(), DeviceStateRequestStateInquiry)
+	be.NilErr(t, err)
+	be.Equal(t, "charge", state)
+	be.Equal(t, "bulk", subState)
+}
+
+func TestAdapter_CommandReadSetting(t *testing.T) {
+	a := newTranscriptAdapter(t, exchange{
+		write: []byte{0x05, 0xff, 'W', 0x31, 0x02, 0x01, 0x71},
+		reply: []byte{0x05, 0xff, 'W', 0x86, 0x10, 0x27, 0xe8},
+	})
+	low, high, err := a.CommandReadSetting(context.Background(), 0x02, 0x01)
+	be.NilErr(t, err)
+	be.Equal(t, byte(0x10), low)
+	be.Equal(t, byte(0x27), high)
+}
+
+func TestAdapter_CommandReadRAMVar(t *testing.T) {
+	ctx := context.Background()
+	request := []byte{0x05, 0xff, 'W', 0x30, 0x04, 0x05, 0x6c}
+
+	t.Run(`4 byte reply`, func(t *testing.T) {
+		a := newTranscriptAdapter(t, exchange{
+			write: request,
+			reply: []byte{0x07, 0xff, 'W', 0x85, 0x28, 0x05, 0xfb, 0xff, 0xf7},
+		})
+		v0l, v0h, v1l, v1h, err := a.CommandReadRAMVar(ctx, 4, 5)
+		be.NilErr(t, err)
+		be.AllEqual(t, []byte{0x28, 0x05, 0xfb, 0xff}, []byte{v0l, v0h, v1l, v1h})
+	})
+	t.Run(`6 byte reply`, func(t *testing.T) {
+		a := newTranscriptAdapter(t, exchange{
+			write: request,
+			reply: []byte{0x09, 0xff, 'W', 0x85, 0x28, 0x05, 0xfb, 0xff, 0x10, 0x5a, 0x8b},
+		})
+		v0, v1, err := a.CommandReadRAMVarSigned16(ctx, 4, 5)
+		be.NilErr(t, err)
+		be.Equal(t, int16(1320), v0)
+		be.Equal(t, int16(-5), v1)
+	})
+	t.Run(`variable not supported`, func(t *testing.T) {
+		a := newTranscriptAdapter(t, exchange{
+			write: []byte{0x05, 0xff, 'W', 0x30, 0x0e, 0x00, 0x67},
+			reply: []byte{0x03, 0xff, 'W', 0x90, 0x17},
+		})
+		_, _, _, _, err := a.CommandReadRAMVar(ctx, 14, 0)
+		be.True(t, errors.Is(err, ErrVariableNotSupported))
+	})
+}
+
+func TestAdapter_CommandWriteRAMVarData(t *testing.T) {
+	a := newTranscriptAdapter(t,
+		exchange{write: []byte{0x05, 0xff, 'W', 0x32, 0x85, 0x00, 0xee}},
+		exchange{
+			write: []byte{0x05, 0xff, 'W', 0x34, 0x38, 0xff, 0x3a},
+			reply: []byte{0x03, 0xff, 'W', 0x87, 0x20},
+		})
+	be.NilErr(t, a.CommandWriteRAMVarDataSigned(context.Background(), 0x85, -200))
+}
+
+func TestAdapter_CommandWriteSettingData(t *testing.T) {
+	ctx := context.Background()
+	t.Run(`ok`, func(t *testing.T) {
+		a := newTranscriptAdapter(t,
+			exchange{write: []byte{0x05, 0xff, 'W', 0x33, 0x02, 0x01, 0x6f}},
+			exchange{
+				write: []byte{0x05, 0xff, 'W', 0x34, 0x0a, 0x00, 0x67},
+				reply: []byte{0x03, 0xff, 'W', 0x88, 0x1f},
+			})
+		be.NilErr(t, a.CommandWriteSettingData(ctx, 0x0102, 0x0a, 0x00))
+	})
+	t.Run(`ram write reply is not a setting write`, func(t *testing.T) {
+		a := newTranscriptAdapter(t,
+			exchange{write: []byte{0x05, 0xff, 'W', 0x33, 0x02, 0x01, 0x6f}},
+			exchange{
+				write: []byte{0x05, 0xff, 'W', 0x34, 0x0a, 0x00, 0x67},
+				reply: []byte{0x03, 0xff, 'W', 0x87, 0x20},
+			})
+		be.Nonzero(t, a.CommandWriteSettingData(ctx, 0x0102, 0x0a, 0x00))
+	})
+}
+
+func TestESSInit(t *testing.T) {
+	ctx := context.Background()
+	read128 := exchange{
+		write: []byte{0x05, 0xff, 'W', 0x30, 0x80, 0x00, 0xf5},
+		reply: []byte{0x07, 0xff, 'W', 0x85, 0x33, 0x00, 0x00, 0x00, 0xeb}, // assistant 3, 3 records
+	}
+
+	t.Run(`found after multiple assistants`, func(t *testing.T) {
+		a := newTranscriptAdapter(t,
+			read128,
+			exchange{
+				write: []byte{0x05, 0xff, 'W', 0x30, 0x84, 0x00, 0xf1},
+				reply: []byte{0x07, 0xff, 'W', 0x85, 0x12, 0x00, 0x00, 0x00, 0x0c}, // assistant 1, 2 records
+			},
+			exchange{
+				write: []byte{0x05, 0xff, 'W', 0x30, 0x87, 0x00, 0xee},
+				reply: []byte{0x07, 0xff, 'W', 0x85, 0x52, 0x00, 0x00, 0x00, 0xcc}, // ESS assistant
+			},
+			// SetpointSet writes to the record following the ESS header
+			exchange{write: []byte{0x05, 0xff, 'W', 0x32, 0x88, 0x00, 0xeb}},
+			exchange{
+				write: []byte{0x05, 0xff, 'W', 0x34, 0xd6, 0xff, 0x9c},
+				reply: []byte{0x03, 0xff, 'W', 0x87, 0x20},
+			},
+		)
+		ess, err := ESSInit(ctx, a)
+		be.NilErr(t, err)
+		be.Equal(t, uint16(135), ess.assistantRAMID)
+		be.NilErr(t, ess.SetpointSet(ctx, -42))
+	})
+	t.Run(`not found`, func(t *testing.T) {
+		a := newTranscriptAdapter(t,
+			read128,
+			exchange{
+				write: []byte{0x05, 0xff, 'W', 0x30, 0x84, 0x00, 0xf1},
+				reply: []byte{0x07, 0xff, 'W', 0x85, 0x00, 0x00, 0x00, 0x00, 0x1e}, // end of records
+			},
+		)
+		_, err := ESSInit(ctx, a)
+		be.Nonzero(t, err)
+	})
+}
@@ -2,24 +2,36 @@ package mk2
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 
 	"github.com/yvesf/ve-ctrl-tool/pkg/vebus"
 )
 
+// ESSCommands extends AdapterCommands by the functions of the ESS Assistant.
+type ESSCommands interface {
+	AdapterCommands
+	SetpointSet(ctx context.Context, value int16) error
+}
+
 // AdapterWithESS is wraps Adapter and adds function to configure the AdapterWithESS Assistant.
 type AdapterWithESS struct {
-	*Adapter
+	AdapterCommands
 	assistantRAMID uint16
 }
 
+var _ ESSCommands = &AdapterWithESS{}
+
+// ErrESSNotFound is returned by ESSInit if the device has no ESS Assistant.
+var ErrESSNotFound = errors.New("ESS RAM Record not found")
+
 // ESSInit searches for the ESS Assistent in RAM
 // if not found returns with error.
-func ESSInit(ctx context.Context, mk2 *Adapter) (*AdapterWithESS, error) {
+func ESSInit(ctx context.Context, mk2 AdapterCommands) (*AdapterWithESS, error) {
 	// 200 is arbitrary chosen upper bound.
 	// should be corrected if information is available.
-	for i := 128; i < 200; i++ {
+	for i := vebus.RAMIDAssistantFirst; i < 200; i++ {
 		slog.Debug("probing ramid", slog.Int("ramID", i))
 		low, high, _, _, err := mk2.CommandReadRAMVar(ctx, byte(i), 0)
 		if err != nil {
@@ -34,8 +46,8 @@ func ESSInit(ctx context.Context, mk2 *Adapter) (*AdapterWithESS, error) {
 		slog.Debug("id", slog.Int("assistantID", int(assistantID)))
 		if assistantID == vebus.AssistantRAMIDESS {
 			return &AdapterWithESS{
-				Adapter:        mk2,
-				assistantRAMID: uint16(i),
+				AdapterCommands: mk2,
+				assistantRAMID:  uint16(i),
 			}, nil
 		}
 
@@ -43,10 +55,15 @@ func ESSInit(ctx context.Context, mk2 *Adapter) (*AdapterWithESS, error) {
 		i += int(low & 0xf)
 	}
 
-	return nil, fmt.Errorf("ESS RAM Record not found")
+	return nil, ErrESSNotFound
+}
+
+// SetpointRAMID returns the RAM ID that SetpointSet writes to.
+func (m *AdapterWithESS) SetpointRAMID() uint16 {
+	return m.assistantRAMID + 1
 }
 
 func (m *AdapterWithESS) SetpointSet(ctx context.Context, value int16) error {
 	slog.Info("write setpoint", slog.Int("value", int(value)), slog.Int("record", int(m.assistantRAMID)))
-	return m.CommandWriteRAMVarDataSigned(ctx, m.assistantRAMID+1, value)
+	return m.CommandWriteRAMVarDataSigned(ctx, m.SetpointRAMID(), value)
 }
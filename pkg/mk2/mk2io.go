@@ -7,7 +7,9 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/goburrow/serial"
@@ -15,6 +17,9 @@ import (
 	"github.com/yvesf/ve-ctrl-tool/pkg/vebus"
 )
 
+// ErrDeviceLocked is returned by NewReader if another process uses the serial device.
+var ErrDeviceLocked = errors.New("serial device is locked")
+
 // IO provides raw read/write to MK2-Adapter.
 type IO struct {
 	listenerProduce chan chan []byte
@@ -23,12 +28,21 @@ type IO struct {
 	input          serial.Port
 	commandMutex   sync.Mutex
 	signalShutdown chan struct{}
-	running        bool
+	running        atomic.Bool
 	wg             sync.WaitGroup
 	config         serial.Config
+	// deviceLock keeps other processes off the device until the reader is shut down.
+	deviceLock *os.File
 }
 
+// NewReader opens the serial device at address. The device is locked exclusively (advisory lock) and
+// ErrDeviceLocked is returned if another process holds the lock.
 func NewReader(address string) (*IO, error) {
+	deviceLock, err := lockDevice(address)
+	if err != nil {
+		return nil, err
+	}
+
 	config := serial.Config{}
 	config.Address = address
 	config.BaudRate = 2400
@@ -39,16 +53,26 @@ func NewReader(address string) (*IO, error) {
 
 	port, err := serial.Open(&config)
 	if err != nil {
+		if deviceLock != nil {
+			_ = deviceLock.Close()
+		}
 		return nil, err
 	}
 
+	reader := newIO(port, config)
+	reader.deviceLock = deviceLock
+	return reader, nil
+}
+
+// newIO returns IO reading from and writing to an already opened port.
+func newIO(port serial.Port, config serial.Config) *IO {
 	return &IO{
 		config:          config,
 		listenerProduce: make(chan chan []byte),
 		listenerClose:   make(chan chan []byte),
 		input:           port,
 		commandMutex:    sync.Mutex{},
-	}, nil
+	}
 }
 
 func (r *IO) SetBaudHigh() error {
@@ -116,12 +140,12 @@ func (r *IO) StartReader() error {
 	waitOnce := sync.Once{}
 
 	r.commandMutex.Lock()
-	if r.running {
+	if r.running.Load() {
 		r.commandMutex.Unlock()
 		return fmt.Errorf("already running")
 	}
 	r.signalShutdown = make(chan struct{})
-	r.running = true
+	r.running.Store(true)
 	r.commandMutex.Unlock()
 
 	r.wg.Add(1)
@@ -165,12 +189,13 @@ func (r *IO) StartReader() error {
 	go func() {
 		defer r.Shutdown()
 		defer r.wg.Done()
+		defer r.unlockDevice()
 		defer close(frames)
 
 		var scannerBuffer bytes.Buffer
 		synchronized := false
 		frameBuf := make([]byte, 1024)
-		for r.running {
+		for r.running.Load() {
 			n, err := r.input.Read(frameBuf)
 			if err != nil {
 				slog.Warn(fmt.Sprintf("Error reading: %v", err))
@@ -280,17 +305,32 @@ func (r *IO) Close(l chan []byte) {
 	}
 }
 
-// Shutdown initiates stop reading.
+// Shutdown initiates stop reading, the device lock is released once the reader stopped.
 // Call Wait() to make sure shutdown is completed.
 func (r *IO) Shutdown() {
 	slog.Debug("try shutdown")
 	r.commandMutex.Lock()
-	if r.running {
+	if r.running.Load() {
 		slog.Debug("trigger shutdown")
 		close(r.signalShutdown)
-		r.running = false
+		r.running.Store(false)
 	}
+	started := r.signalShutdown != nil
 	r.commandMutex.Unlock()
+	if !started {
+		// without reader nothing else releases the lock.
+		r.unlockDevice()
+	}
+}
+
+// unlockDevice releases the device lock taken by NewReader.
+func (r *IO) unlockDevice() {
+	r.commandMutex.Lock()
+	defer r.commandMutex.Unlock()
+	if r.deviceLock != nil {
+		_ = r.deviceLock.Close()
+		r.deviceLock = nil
+	}
 }
 
 // Wait blocks until all reader go-routines finished.
@@ -0,0 +1,256 @@
+// Package mk2test provides an in-memory replacement for mk2.Adapter to unit test consumers of pkg/mk2
+// without hardware.
+package mk2test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/yvesf/ve-ctrl-tool/pkg/mk2"
+	"github.com/yvesf/ve-ctrl-tool/pkg/vebus"
+)
+
+// Call is a recorded invocation of one of the Fake methods.
+type Call struct {
+	Method string
+	Args   []any
+}
+
+func (c Call) String() string {
+	return fmt.Sprintf("%s%v", c.Method, c.Args)
+}
+
+// Fake implements mk2.ESSCommands in memory.
+// RAM variables and settings are served from the RAM and Settings maps, reading unknown IDs fails like on a
+// real device with mk2.ErrVariableNotSupported or mk2.ErrSettingNotSupported. An unknown second RAM ID
+// reads as zero, like on old devices that reply only with the first variable.
+// Every call is recorded and can be inspected with Calls. Errors can be injected with FailNext.
+type Fake struct {
+	lock sync.Mutex
+
+	Address  byte
+	State    mk2.DeviceStateResponseState
+	SubState mk2.DeviceStateResponseSubState
+	RAM      map[uint16]uint16
+	Settings map[uint16]uint16
+	Setpoint int16
+
+	calls []Call
+	fail  map[string][]error
+}
+
+var _ mk2.ESSCommands = &Fake{}
+
+// NewFake returns a Fake with empty RAM and settings in device state "charge/bulk".
+func NewFake() *Fake {
+	return &Fake{
+		State:    mk2.DeviceStateResponseStates[0x09],
+		SubState: mk2.DeviceStateResponseSubStates[0x01],
+		RAM:      make(map[uint16]uint16),
+		Settings: make(map[uint16]uint16),
+		fail:     make(map[string][]error),
+	}
+}
+
+// SetRAMSigned stores value in the RAM variable id using the VE.Bus signed encoding.
+func (f *Fake) SetRAMSigned(id uint16, value int16) {
+	low, high := vebus.Signed16Bytes(value)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.RAM[id] = uint16(low) | uint16(high)<<8
+}
+
+// FailNext makes the next call to method return err instead of executing it.
+// Multiple errors for the same method are returned in the order they were added.
+func (f *Fake) FailNext(method string, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.fail[method] = append(f.fail[method], err)
+}
+
+// Calls returns all recorded calls in order.
+func (f *Fake) Calls() []Call {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// CallsTo returns the recorded calls to method in order.
+func (f *Fake) CallsTo(method string) []Call {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var calls []Call
+	for _, c := range f.calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// record must be called with lock held. It returns the injected error, if any.
+func (f *Fake) record(method string, args ...any) error {
+	f.calls = append(f.calls, Call{Method: method, Args: args})
+	if errs := f.fail[method]; len(errs) > 0 {
+		f.fail[method] = errs[1:]
+		return errs[0]
+	}
+	return nil
+}
+
+func (f *Fake) SetAddress(_ context.Context, address byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.record("SetAddress", address); err != nil {
+		return err
+	}
+	f.Address = address
+	return nil
+}
+
+func (f *Fake) GetAddress(context.Context) (byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.record("GetAddress"); err != nil {
+		return 0, err
+	}
+	return f.Address, nil
+}
+
+func (f *Fake) Reset(context.Context) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.record("Reset")
+}
+
+func (f *Fake) CommandGetSetDeviceState(_ context.Context, setState mk2.DeviceStateRequestState,
+) (mk2.DeviceStateResponseState, mk2.DeviceStateResponseSubState, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.record("CommandGetSetDeviceState", setState); err != nil {
+		return "", "", err
+	}
+	switch setState {
+	case mk2.DeviceStateRequestStateForceToEqualise:
+		f.SubState = mk2.DeviceStateResponseSubStates[0x07]
+	case mk2.DeviceStateRequestStateForceToAbsorption:
+		f.SubState = mk2.DeviceStateResponseSubStates[0x06]
+	case mk2.DeviceStateRequestStateForceToFloat:
+		f.SubState = mk2.DeviceStateResponseSubStates[0x03]
+	}
+	return f.State, f.SubState, nil
+}
+
+func (f *Fake) CommandReadSetting(_ context.Context, lowSettingID, highSettingID byte,
+) (lowValue, highValue byte, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.record("CommandReadSetting", lowSettingID, highSettingID); err != nil {
+		return 0, 0, err
+	}
+	v, ok := f.Settings[uint16(lowSettingID)|uint16(highSettingID)<<8]
+	if !ok {
+		return 0, 0, mk2.ErrSettingNotSupported
+	}
+	return byte(v), byte(v >> 8), nil
+}
+
+func (f *Fake) CommandReadRAMVar(_ context.Context, ramID0, ramID1 byte,
+) (value0Low, value0High, value1Low, value1High byte, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.record("CommandReadRAMVar", ramID0, ramID1); err != nil {
+		return 0, 0, 0, 0, err
+	}
+	return f.readRAM(ramID0, ramID1)
+}
+
+func (f *Fake) CommandReadRAMVarUnsigned16(_ context.Context, ramID0, ramID1 byte,
+) (value0, value1 uint16, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.record("CommandReadRAMVarUnsigned16", ramID0, ramID1); err != nil {
+		return 0, 0, err
+	}
+	v0l, v0h, v1l, v1h, err := f.readRAM(ramID0, ramID1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint16(v0l) | uint16(v0h)<<8, uint16(v1l) | uint16(v1h)<<8, nil
+}
+
+func (f *Fake) CommandReadRAMVarSigned16(_ context.Context, ramID0, ramID1 byte,
+) (value0, value1 int16, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.record("CommandReadRAMVarSigned16", ramID0, ramID1); err != nil {
+		return 0, 0, err
+	}
+	v0l, v0h, v1l, v1h, err := f.readRAM(ramID0, ramID1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return vebus.ParseSigned16Bytes(v0l, v0h), vebus.ParseSigned16Bytes(v1l, v1h), nil
+}
+
+// readRAM must be called with lock held.
+func (f *Fake) readRAM(ramID0, ramID1 byte) (value0Low, value0High, value1Low, value1High byte, err error) {
+	v0, ok := f.RAM[uint16(ramID0)]
+	if !ok {
+		return 0, 0, 0, 0, mk2.ErrVariableNotSupported
+	}
+	v1 := f.RAM[uint16(ramID1)]
+	return byte(v0), byte(v0 >> 8), byte(v1), byte(v1 >> 8), nil
+}
+
+func (f *Fake) CommandWriteRAMVarDataSigned(_ context.Context, ram uint16, value int16) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.record("CommandWriteRAMVarDataSigned", ram, value); err != nil {
+		return err
+	}
+	low, high := vebus.Signed16Bytes(value)
+	f.RAM[ram] = uint16(low) | uint16(high)<<8
+	return nil
+}
+
+func (f *Fake) CommandWriteRAMVarData(_ context.Context, ram uint16, low, high byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.record("CommandWriteRAMVarData", ram, low, high); err != nil {
+		return err
+	}
+	f.RAM[ram] = uint16(low) | uint16(high)<<8
+	return nil
+}
+
+func (f *Fake) CommandWriteViaID(_ context.Context, id byte, dataLow, dataHigh byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.record("CommandWriteViaID", id, dataLow, dataHigh); err != nil {
+		return err
+	}
+	f.RAM[uint16(id)] = uint16(dataLow) | uint16(dataHigh)<<8
+	return nil
+}
+
+func (f *Fake) CommandWriteSettingData(_ context.Context, setting uint16, dataLow, dataHigh byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.record("CommandWriteSettingData", setting, dataLow, dataHigh); err != nil {
+		return err
+	}
+	f.Settings[setting] = uint16(dataLow) | uint16(dataHigh)<<8
+	return nil
+}
+
+func (f *Fake) SetpointSet(_ context.Context, value int16) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.record("SetpointSet", value); err != nil {
+		return err
+	}
+	f.Setpoint = value
+	return nil
+}
@@ -0,0 +1,27 @@
+package mk2test_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+
+	"github.com/yvesf/ve-ctrl-tool/pkg/mk2"
+	"github.com/yvesf/ve-ctrl-tool/pkg/mk2/mk2test"
+)
+
+func TestFake_ESSInit(t *testing.T) {
+	ctx := context.Background()
+	fake := mk2test.NewFake()
+	fake.RAM[128] = 0x0033 // assistant 3 with 3 records
+	fake.RAM[132] = 0x0052 // ESS assistant
+	fake.RAM[135] = 0x0000 // end of records
+
+	ess, err := mk2.ESSInit(ctx, fake)
+	be.NilErr(t, err)
+	be.NilErr(t, ess.SetpointSet(ctx, -42))
+
+	calls := fake.CallsTo("CommandWriteRAMVarDataSigned")
+	be.Equal(t, 1, len(calls))
+	be.AllEqual(t, []any{uint16(133), int16(-42)}, calls[0].Args)
+}
@@ -0,0 +1,166 @@
+package modbusmeter
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Register is a 32 bit IEEE 754 float value in two registers, high word first.
+type Register struct {
+	Address uint16
+	// Scale converts the register value to the SI unit.
+	Scale float64
+}
+
+// PhaseRegisters are the registers of a single phase.
+type PhaseRegisters struct {
+	Power   Register // [Watt]
+	Voltage Register // [Volt] line to neutral
+	Current Register // [Ampere]
+}
+
+// Model is the register map of a meter type.
+type Model struct {
+	// Function code used to read the registers.
+	Function byte
+	// Power is the total active power [Watt], positive values are import from the grid.
+	Power     Register
+	Frequency Register // [Hz]
+	// Phases L1, L2 and L3, only one for single phase meters.
+	Phases []PhaseRegisters
+}
+
+// eastronPhase returns the registers of phase i of Eastron meters.
+func eastronPhase(i uint16) PhaseRegisters {
+	return PhaseRegisters{
+		Power:   Register{Address: 0x000c + 2*i, Scale: 1},
+		Voltage: Register{Address: 0x0000 + 2*i, Scale: 1},
+		Current: Register{Address: 0x0006 + 2*i, Scale: 1},
+	}
+}
+
+// dtsu666Phase returns the registers of phase i of the Chint DTSU666.
+func dtsu666Phase(i uint16) PhaseRegisters {
+	return PhaseRegisters{
+		Power:   Register{Address: 0x2014 + 2*i, Scale: 0.1},
+		Voltage: Register{Address: 0x2006 + 2*i, Scale: 0.1},
+		Current: Register{Address: 0x200c + 2*i, Scale: 0.001},
+	}
+}
+
+// Models are the register maps of supported meters by name.
+var Models = map[string]Model{
+	"sdm630": {
+		Function:  FuncReadInputRegisters,
+		Power:     Register{Address: 0x0034, Scale: 1},
+		Frequency: Register{Address: 0x0046, Scale: 1},
+		Phases:    []PhaseRegisters{eastronPhase(0), eastronPhase(1), eastronPhase(2)},
+	},
+	"sdm72": {
+		Function:  FuncReadInputRegisters,
+		Power:     Register{Address: 0x0034, Scale: 1},
+		Frequency: Register{Address: 0x0046, Scale: 1},
+		Phases:    []PhaseRegisters{eastronPhase(0), eastronPhase(1), eastronPhase(2)},
+	},
+	"sdm120": {
+		Function:  FuncReadInputRegisters,
+		Power:     Register{Address: 0x000c, Scale: 1},
+		Frequency: Register{Address: 0x0046, Scale: 1},
+		Phases:    []PhaseRegisters{eastronPhase(0)},
+	},
+	"dtsu666": {
+		Function:  FuncReadHoldingRegisters,
+		Power:     Register{Address: 0x2012, Scale: 0.1},
+		Frequency: Register{Address: 0x2044, Scale: 0.01},
+		Phases:    []PhaseRegisters{dtsu666Phase(0), dtsu666Phase(1), dtsu666Phase(2)},
+	},
+}
+
+// Data is a reading of the meter.
+type Data struct {
+	// Power is the total active power [Watt], positive values are import from the grid.
+	Power     float64
+	Frequency float64
+	Phases    []PhaseData
+}
+
+// PhaseData is the reading of a single phase.
+type PhaseData struct {
+	Power   float64
+	Voltage float64
+	Current float64
+}
+
+// Meter reads a Modbus meter.
+type Meter struct {
+	Client Client
+	Model  Model
+}
+
+// Read reads all registers of the model with a single request.
+func (m Meter) Read(ctx context.Context) (*Data, error) {
+	first, last := m.Model.span()
+	quantity := last - first + 2
+	if quantity > maxQuantity {
+		return nil, fmt.Errorf("registers span %d registers, more than %d", quantity, maxQuantity)
+	}
+	values, err := m.Client.ReadRegisters(ctx, m.Model.Function, first, quantity)
+	if err != nil {
+		return nil, err
+	}
+
+	read := func(r Register) float64 {
+		offset := 2 * (r.Address - first)
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(values[offset:]))) * r.Scale
+	}
+	data := &Data{
+		Power:     read(m.Model.Power),
+		Frequency: read(m.Model.Frequency),
+	}
+	for _, p := range m.Model.Phases {
+		data.Phases = append(data.Phases, PhaseData{
+			Power:   read(p.Power),
+			Voltage: read(p.Voltage),
+			Current: read(p.Current),
+		})
+	}
+	return data, nil
+}
+
+// Encode returns the register values that represent d. It is the inverse of Meter.Read used to
+// simulate meters.
+func (m Model) Encode(d Data) map[uint16]uint16 {
+	values := make(map[uint16]uint16)
+	write := func(r Register, v float64) {
+		bits := math.Float32bits(float32(v / r.Scale))
+		values[r.Address] = uint16(bits >> 16)
+		values[r.Address+1] = uint16(bits)
+	}
+	write(m.Power, d.Power)
+	write(m.Frequency, d.Frequency)
+	for i, p := range m.Phases {
+		if i >= len(d.Phases) {
+			break
+		}
+		write(p.Power, d.Phases[i].Power)
+		write(p.Voltage, d.Phases[i].Voltage)
+		write(p.Current, d.Phases[i].Current)
+	}
+	return values
+}
+
+// span returns the lowest and highest register address of the model.
+func (m Model) span() (first, last uint16) {
+	first, last = m.Power.Address, m.Power.Address
+	registers := []Register{m.Frequency}
+	for _, p := range m.Phases {
+		registers = append(registers, p.Power, p.Voltage, p.Current)
+	}
+	for _, r := range registers {
+		first = min(first, r.Address)
+		last = max(last, r.Address)
+	}
+	return first, last
+}
@@ -0,0 +1,142 @@
+package modbusmeter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestMeter(t *testing.T) {
+	data := Data{
+		Power:     -1234.5,
+		Frequency: 50.01,
+		Phases: []PhaseData{
+			{Power: -1000, Voltage: 230.5, Current: 4.25},
+			{Power: -300, Voltage: 231, Current: 1.5},
+			{Power: 65.5, Voltage: 229.5, Current: 0.5},
+		},
+	}
+
+	for name, model := range Models {
+		t.Run(name, func(t *testing.T) {
+			data := data
+			if len(model.Phases) == 1 {
+				data.Power = data.Phases[0].Power // the total is the phase register
+			}
+			s := NewServer(1)
+			s.SetRegisters(model.Function, model.Encode(data))
+			m := Meter{Client: startTCPServer(t, s), Model: model}
+
+			d, err := m.Read(context.Background())
+			be.NilErr(t, err)
+			be.True(t, inRange(data.Power, d.Power))
+			be.True(t, inRange(data.Frequency, d.Frequency))
+			be.Equal(t, len(model.Phases), len(d.Phases))
+			for i, p := range d.Phases {
+				be.True(t, inRange(data.Phases[i].Power, p.Power))
+				be.True(t, inRange(data.Phases[i].Voltage, p.Voltage))
+				be.True(t, inRange(data.Phases[i].Current, p.Current))
+			}
+		})
+	}
+
+	t.Run(`dtsu666 register scale`, func(t *testing.T) {
+		s := NewServer(1)
+		// total active power -1234.5 W as float32 in 0.1 W
+		s.SetRegisters(FuncReadHoldingRegisters, map[uint16]uint16{0x2012: 0xc640, 0x2013: 0xe400})
+		m := Meter{Client: startTCPServer(t, s), Model: Models["dtsu666"]}
+		d, err := m.Read(context.Background())
+		be.NilErr(t, err)
+		be.True(t, inRange(-1234.5, d.Power))
+	})
+}
+
+func TestModels(t *testing.T) {
+	// register words by the address in the datasheets, the values are IEEE 754 float32, high word first.
+	eastron3Phase := map[uint16]uint16{
+		0x0000: 0x4366, 0x0001: 0x8000, // 30001 phase 1 line to neutral volts: 230.5
+		0x0002: 0x4367, 0x0003: 0x0000, // 30003 phase 2 line to neutral volts: 231
+		0x0004: 0x4365, 0x0005: 0x8000, // 30005 phase 3 line to neutral volts: 229.5
+		0x0006: 0x4088, 0x0007: 0x0000, // 30007 phase 1 current: 4.25
+		0x0008: 0x3fc0, 0x0009: 0x0000, // 30009 phase 2 current: 1.5
+		0x000a: 0x3f00, 0x000b: 0x0000, // 30011 phase 3 current: 0.5
+		0x000c: 0xc47a, 0x000d: 0x0000, // 30013 phase 1 power: -1000
+		0x000e: 0xc396, 0x000f: 0x0000, // 30015 phase 2 power: -300
+		0x0010: 0x4283, 0x0011: 0x0000, // 30017 phase 3 power: 65.5
+		0x0034: 0xc49a, 0x0035: 0x5000, // 30053 total system power: -1234.5
+		0x0046: 0x4248, 0x0047: 0x0000, // 30071 frequency: 50
+	}
+	threePhase := Data{
+		Power:     -1234.5,
+		Frequency: 50,
+		Phases: []PhaseData{
+			{Power: -1000, Voltage: 230.5, Current: 4.25},
+			{Power: -300, Voltage: 231, Current: 1.5},
+			{Power: 65.5, Voltage: 229.5, Current: 0.5},
+		},
+	}
+
+	tests := []struct {
+		model     string
+		registers map[uint16]uint16
+		expected  Data
+	}{
+		{model: "sdm630", registers: eastron3Phase, expected: threePhase},
+		{model: "sdm72", registers: eastron3Phase, expected: threePhase},
+		{
+			model: "sdm120",
+			registers: map[uint16]uint16{
+				0x0000: 0x4366, 0x0001: 0x8000, // 30001 voltage: 230.5
+				0x0006: 0x4088, 0x0007: 0x0000, // 30007 current: 4.25
+				0x000c: 0xc47a, 0x000d: 0x0000, // 30013 active power: -1000
+				0x0046: 0x4248, 0x0047: 0x0000, // 30071 frequency: 50
+			},
+			expected: Data{
+				Power:     -1000,
+				Frequency: 50,
+				Phases:    []PhaseData{{Power: -1000, Voltage: 230.5, Current: 4.25}},
+			},
+		},
+		{
+			model: "dtsu666",
+			registers: map[uint16]uint16{
+				0x2006: 0x4510, 0x2007: 0x1000, // Ua [0.1 V]: 2305
+				0x2008: 0x4510, 0x2009: 0x6000, // Ub [0.1 V]: 2310
+				0x200a: 0x450f, 0x200b: 0x7000, // Uc [0.1 V]: 2295
+				0x200c: 0x4584, 0x200d: 0xd000, // Ia [0.001 A]: 4250
+				0x200e: 0x44bb, 0x200f: 0x8000, // Ib [0.001 A]: 1500
+				0x2010: 0x43fa, 0x2011: 0x0000, // Ic [0.001 A]: 500
+				0x2012: 0xc640, 0x2013: 0xe400, // Pt [0.1 W]: -12345
+				0x2014: 0xc61c, 0x2015: 0x4000, // Pa [0.1 W]: -10000
+				0x2016: 0xc53b, 0x2017: 0x8000, // Pb [0.1 W]: -3000
+				0x2018: 0x4423, 0x2019: 0xc000, // Pc [0.1 W]: 655
+				0x2044: 0x459c, 0x2045: 0x4000, // Freq [0.01 Hz]: 5000
+			},
+			expected: threePhase,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			model := Models[tt.model]
+			s := NewServer(1)
+			s.SetRegisters(model.Function, tt.registers)
+			m := Meter{Client: startTCPServer(t, s), Model: model}
+
+			d, err := m.Read(context.Background())
+			be.NilErr(t, err)
+			be.True(t, inRange(tt.expected.Power, d.Power))
+			be.True(t, inRange(tt.expected.Frequency, d.Frequency))
+			be.Equal(t, len(tt.expected.Phases), len(d.Phases))
+			for i, p := range d.Phases {
+				be.True(t, inRange(tt.expected.Phases[i].Power, p.Power))
+				be.True(t, inRange(tt.expected.Phases[i].Voltage, p.Voltage))
+				be.True(t, inRange(tt.expected.Phases[i].Current, p.Current))
+			}
+		})
+	}
+}
+
+func inRange(expected, value float64) bool {
+	return value > expected-0.001 && value < expected+0.001
+}
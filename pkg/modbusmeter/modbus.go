@@ -0,0 +1,80 @@
+// Package modbusmeter reads energy meters with Modbus TCP or Modbus RTU. Only reading of holding and
+// input registers is implemented.
+package modbusmeter
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Function codes.
+const (
+	FuncReadHoldingRegisters byte = 0x03
+	FuncReadInputRegisters   byte = 0x04
+)
+
+// Exception codes.
+const (
+	ExceptionIllegalFunction    byte = 0x01
+	ExceptionIllegalDataAddress byte = 0x02
+	ExceptionIllegalDataValue   byte = 0x03
+)
+
+// maxQuantity is the maximum number of registers in a single read request.
+const maxQuantity = 125
+
+// ExceptionError is the exception response of a Modbus device.
+type ExceptionError struct {
+	Function byte
+	Code     byte
+}
+
+func (e ExceptionError) Error() string {
+	return fmt.Sprintf("modbus exception %#02x for function %#02x", e.Code, e.Function)
+}
+
+// Client reads registers from a Modbus device.
+type Client interface {
+	// ReadRegisters reads quantity registers starting at address with function FuncReadHoldingRegisters
+	// or FuncReadInputRegisters. The register values are returned big-endian as sent by the device.
+	ReadRegisters(ctx context.Context, function byte, address, quantity uint16) ([]byte, error)
+}
+
+// readRequest returns the PDU of a read registers request.
+func readRequest(function byte, address, quantity uint16) []byte {
+	pdu := []byte{function}
+	pdu = binary.BigEndian.AppendUint16(pdu, address)
+	return binary.BigEndian.AppendUint16(pdu, quantity)
+}
+
+// parseReadResponse returns the register values in the PDU of a read registers response.
+func parseReadResponse(function byte, quantity uint16, pdu []byte) ([]byte, error) {
+	if len(pdu) == 2 && pdu[0] == function|0x80 {
+		return nil, ExceptionError{Function: function, Code: pdu[1]}
+	}
+	if len(pdu) < 2 || pdu[0] != function {
+		return nil, fmt.Errorf("unexpected response % x", pdu)
+	}
+	if int(pdu[1]) != 2*int(quantity) || len(pdu) != 2+int(pdu[1]) {
+		return nil, errors.New("invalid response length")
+	}
+	return pdu[2:], nil
+}
+
+// crc16 is the CRC of Modbus RTU frames. It is appended little-endian.
+func crc16(data []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for range 8 {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,86 @@
+package modbusmeter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+// startTCPServer serves s on a local port and returns a client connected to it.
+func startTCPServer(t *testing.T, s *Server) *TCPClient {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	be.NilErr(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.ServeTCP(ctx, ln) }()
+
+	c := &TCPClient{Addr: ln.Addr().String(), UnitID: s.UnitID}
+	t.Cleanup(func() {
+		_ = c.Close()
+		cancel()
+		be.NilErr(t, <-done)
+	})
+	return c
+}
+
+// startRTUServer serves s on one end of a pipe and returns a client on the other end.
+func startRTUServer(t *testing.T, s *Server) *RTUClient {
+	t.Helper()
+	clientEnd, serverEnd := net.Pipe()
+	go func() { _ = s.ServeRTU(serverEnd) }()
+	t.Cleanup(func() { _ = clientEnd.Close() })
+	return &RTUClient{Port: clientEnd, UnitID: s.UnitID}
+}
+
+func TestCRC16(t *testing.T) {
+	be.Equal(t, uint16(0xcdc5), crc16([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0a}))
+}
+
+func TestClients(t *testing.T) {
+	ctx := context.Background()
+	for name, newClient := range map[string]func(*testing.T, *Server) Client{
+		"tcp": func(t *testing.T, s *Server) Client { return startTCPServer(t, s) },
+		"rtu": func(t *testing.T, s *Server) Client { return startRTUServer(t, s) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := NewServer(1)
+			s.SetRegisters(FuncReadInputRegisters, map[uint16]uint16{10: 0x1234, 11: 0xabcd})
+			s.SetRegisters(FuncReadHoldingRegisters, map[uint16]uint16{10: 0x0001})
+			c := newClient(t, s)
+
+			values, err := c.ReadRegisters(ctx, FuncReadInputRegisters, 9, 3)
+			be.NilErr(t, err)
+			be.AllEqual(t, []byte{0, 0, 0x12, 0x34, 0xab, 0xcd}, values)
+
+			values, err = c.ReadRegisters(ctx, FuncReadHoldingRegisters, 10, 1)
+			be.NilErr(t, err)
+			be.AllEqual(t, []byte{0, 1}, values)
+
+			_, err = c.ReadRegisters(ctx, FuncReadInputRegisters, 0, 126)
+			var exception ExceptionError
+			be.True(t, errors.As(err, &exception))
+			be.Equal(t, ExceptionIllegalDataValue, exception.Code)
+
+			_, err = c.ReadRegisters(ctx, 0x11, 0, 1)
+			be.True(t, errors.As(err, &exception))
+			be.Equal(t, ExceptionIllegalFunction, exception.Code)
+		})
+	}
+}
+
+func TestTCPClient_reconnect(t *testing.T) {
+	c := startTCPServer(t, NewServer(1))
+	_, err := c.ReadRegisters(context.Background(), FuncReadInputRegisters, 0, 1)
+	be.NilErr(t, err)
+
+	// a broken connection fails the request and is replaced by the next request.
+	_ = c.conn.Close()
+	_, err = c.ReadRegisters(context.Background(), FuncReadInputRegisters, 0, 1)
+	be.Nonzero(t, err)
+	_, err = c.ReadRegisters(context.Background(), FuncReadInputRegisters, 0, 1)
+	be.NilErr(t, err)
+}
@@ -0,0 +1,68 @@
+package modbusmeter
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// RTUClient is a Modbus RTU client on a serial line. The timeout of requests is the read timeout of Port,
+// the context is only checked before sending.
+type RTUClient struct {
+	Port   io.ReadWriter
+	UnitID byte
+
+	lock sync.Mutex
+}
+
+func (c *RTUClient) ReadRegisters(ctx context.Context, function byte, address, quantity uint16) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	frame := append([]byte{c.UnitID}, readRequest(function, address, quantity)...)
+	frame = binary.LittleEndian.AppendUint16(frame, crc16(frame))
+	_, err := c.Port.Write(frame)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	response, err := readRTUResponse(c.Port)
+	if err != nil {
+		return nil, err
+	}
+	if response[0] != c.UnitID {
+		return nil, fmt.Errorf("response from unexpected unit %d", response[0])
+	}
+	return parseReadResponse(function, quantity, response[1:])
+}
+
+// readRTUResponse reads a read registers response or exception frame and checks the CRC. The returned
+// frame starts with the unit id and excludes the CRC.
+func readRTUResponse(r io.Reader) ([]byte, error) {
+	frame := make([]byte, 3, 3+255+2)
+	_, err := io.ReadFull(r, frame)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	remaining := 2 // exception response: unit, function, code, crc
+	if frame[1]&0x80 == 0 {
+		remaining += int(frame[2]) // byte count
+	}
+	frame = frame[:3+remaining]
+	_, err = io.ReadFull(r, frame[3:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	data, crc := frame[:len(frame)-2], binary.LittleEndian.Uint16(frame[len(frame)-2:])
+	if crc16(data) != crc {
+		return nil, errors.New("invalid crc in response")
+	}
+	return data, nil
+}
@@ -0,0 +1,155 @@
+package modbusmeter
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+)
+
+// Server is a minimal Modbus server answering read register requests from register tables. It stands in
+// for a meter in tests and simulators. Registers that are not set read as zero.
+type Server struct {
+	// UnitID of the server, requests to other unit ids are ignored on RTU.
+	UnitID byte
+
+	lock      sync.Mutex
+	registers map[byte]map[uint16]uint16 // function code to register table
+}
+
+// NewServer returns a server with empty register tables.
+func NewServer(unitID byte) *Server {
+	return &Server{
+		UnitID: unitID,
+		registers: map[byte]map[uint16]uint16{
+			FuncReadHoldingRegisters: {},
+			FuncReadInputRegisters:   {},
+		},
+	}
+}
+
+// SetRegisters sets the registers read by function.
+func (s *Server) SetRegisters(function byte, values map[uint16]uint16) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	table, ok := s.registers[function]
+	if !ok {
+		return
+	}
+	for address, value := range values {
+		table[address] = value
+	}
+}
+
+// ServeTCP accepts Modbus TCP connections on ln until ctx is cancelled.
+func (s *Server) ServeTCP(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+			defer stop()
+			defer conn.Close()
+			err := s.serveTCPConn(conn)
+			if err != nil && !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				slog.Error("modbus connection failed", slog.Any("err", err))
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCPConn(conn net.Conn) error {
+	for {
+		var header [7]byte
+		_, err := io.ReadFull(conn, header[:])
+		if err != nil {
+			return err
+		}
+		length := binary.BigEndian.Uint16(header[4:6])
+		if length < 2 || length > 254 {
+			return errors.New("invalid request length")
+		}
+		request := make([]byte, length-1)
+		_, err = io.ReadFull(conn, request)
+		if err != nil {
+			return err
+		}
+
+		response := s.handle(request)
+		frame := append([]byte{}, header[:4]...)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(response)+1))
+		frame = append(frame, header[6])
+		frame = append(frame, response...)
+		_, err = conn.Write(frame)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ServeRTU answers read register requests on a serial line until reading from rw fails.
+// Only read register requests are supported, each request frame is 8 bytes.
+func (s *Server) ServeRTU(rw io.ReadWriter) error {
+	for {
+		frame := make([]byte, 8)
+		_, err := io.ReadFull(rw, frame)
+		if err != nil {
+			return err
+		}
+		data, crc := frame[:6], binary.LittleEndian.Uint16(frame[6:])
+		if crc16(data) != crc || data[0] != s.UnitID {
+			continue // not a valid request for this unit
+		}
+
+		response := append([]byte{s.UnitID}, s.handle(data[1:])...)
+		response = binary.LittleEndian.AppendUint16(response, crc16(response))
+		_, err = rw.Write(response)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handle returns the response PDU to the request PDU.
+func (s *Server) handle(pdu []byte) []byte {
+	function := pdu[0]
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	table, ok := s.registers[function]
+	if !ok || len(pdu) != 5 {
+		return []byte{function | 0x80, ExceptionIllegalFunction}
+	}
+	address := binary.BigEndian.Uint16(pdu[1:3])
+	quantity := binary.BigEndian.Uint16(pdu[3:5])
+	if quantity == 0 || quantity > maxQuantity {
+		return []byte{function | 0x80, ExceptionIllegalDataValue}
+	}
+	if int(address)+int(quantity) > 0x10000 {
+		return []byte{function | 0x80, ExceptionIllegalDataAddress}
+	}
+
+	response := []byte{function, byte(2 * quantity)}
+	for i := range quantity {
+		response = binary.BigEndian.AppendUint16(response, table[address+i])
+	}
+	return response
+}
@@ -0,0 +1,105 @@
+package modbusmeter
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// TCPClient is a Modbus TCP client. The connection is opened on the first request and reopened after errors.
+type TCPClient struct {
+	// Addr is the host:port of the device or gateway.
+	Addr string
+	// UnitID addresses the device behind a gateway, most devices accept any unit id.
+	UnitID byte
+	// Timeout of a request if the context has no deadline. Defaults to 1 second.
+	Timeout time.Duration
+
+	lock          sync.Mutex
+	conn          net.Conn
+	transactionID uint16
+}
+
+// Close closes the connection.
+func (c *TCPClient) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+func (c *TCPClient) ReadRegisters(ctx context.Context, function byte, address, quantity uint16) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		timeout := c.Timeout
+		if timeout == 0 {
+			timeout = time.Second
+		}
+		deadline = time.Now().Add(timeout)
+	}
+
+	if c.conn == nil {
+		dialer := net.Dialer{Deadline: deadline}
+		conn, err := dialer.DialContext(ctx, "tcp", c.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect: %w", err)
+		}
+		c.conn = conn
+	}
+
+	pdu, err := c.roundTrip(deadline, readRequest(function, address, quantity))
+	if err != nil {
+		// the state of the connection is unknown, reconnect with the next request.
+		_ = c.conn.Close()
+		c.conn = nil
+		return nil, err
+	}
+	return parseReadResponse(function, quantity, pdu)
+}
+
+// roundTrip sends the request PDU and returns the response PDU.
+func (c *TCPClient) roundTrip(deadline time.Time, pdu []byte) ([]byte, error) {
+	_ = c.conn.SetDeadline(deadline)
+
+	c.transactionID++
+	frame := binary.BigEndian.AppendUint16(nil, c.transactionID)
+	frame = binary.BigEndian.AppendUint16(frame, 0) // protocol id
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(pdu)+1))
+	frame = append(frame, c.UnitID)
+	frame = append(frame, pdu...)
+	_, err := c.conn.Write(frame)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	var header [7]byte
+	_, err = io.ReadFull(c.conn, header[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if binary.BigEndian.Uint16(header[0:2]) != c.transactionID {
+		return nil, errors.New("unexpected transaction id in response")
+	}
+	length := binary.BigEndian.Uint16(header[4:6])
+	if length < 2 || length > 254 {
+		return nil, errors.New("invalid response length")
+	}
+	response := make([]byte, length-1)
+	_, err = io.ReadFull(c.conn, response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return response, nil
+}
@@ -0,0 +1,216 @@
+// Package p1 reads the DSMR telegrams sent on the P1 port of dutch and belgian smart meters.
+package p1
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrChecksum is returned by Parse if the CRC of the telegram does not match.
+var ErrChecksum = errors.New("p1: checksum mismatch")
+
+// maxTelegramSize limits the size of a telegram to recover from a lost end line.
+const maxTelegramSize = 16384
+
+// Telegram are the electricity values of a DSMR telegram. Power is in Watt, energy in Wh.
+type Telegram struct {
+	// Identification is the header line without the leading '/'.
+	Identification string
+	// ImportPower and ExportPower are the current power taken from and fed into the grid.
+	ImportPower float64
+	ExportPower float64
+	// ImportEnergy and ExportEnergy are the counters summed over all tariffs.
+	ImportEnergy float64
+	ExportEnergy float64
+	// Phases L1, L2, L3. Only the phases present in the telegram, empty for meters without per phase values.
+	Phases []Phase
+}
+
+// Phase are the instantaneous values of a phase.
+type Phase struct {
+	ImportPower float64
+	ExportPower float64
+	Voltage     float64
+	Current     float64
+}
+
+// Power returns the net power flow, positive values are import from the grid.
+func (t Telegram) Power() float64 {
+	return t.ImportPower - t.ExportPower
+}
+
+// Power returns the net power flow of the phase, positive values are import from the grid.
+func (p Phase) Power() float64 {
+	return p.ImportPower - p.ExportPower
+}
+
+// Reader reads telegrams from a stream.
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// ReadTelegram returns the next telegram from '/' to the end line starting with '!'. Data before the
+// start of the telegram is skipped.
+func (r *Reader) ReadTelegram() ([]byte, error) {
+	_, err := r.r.ReadBytes('/')
+	if err != nil {
+		return nil, err
+	}
+	telegram := []byte{'/'}
+	for len(telegram) < maxTelegramSize {
+		line, err := r.r.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+		telegram = append(telegram, line...)
+		if line[0] == '!' {
+			return telegram, nil
+		}
+	}
+	return nil, errors.New("p1: telegram too large")
+}
+
+// Parse validates the CRC and returns the electricity values of telegram. Telegrams of DSMR before
+// version 4 have no CRC and are accepted without, the version is taken from the 1-3:0.2.8 or
+// 0-0:96.1.4 (e-MUCS) object.
+func Parse(telegram []byte) (*Telegram, error) {
+	end := bytes.LastIndexByte(telegram, '!')
+	if len(telegram) == 0 || telegram[0] != '/' || end < 0 {
+		return nil, errors.New("p1: incomplete telegram")
+	}
+	lines := strings.Split(string(telegram[1:end]), "\n")
+	if checksum := strings.TrimSpace(string(telegram[end+1:])); checksum != "" {
+		expected, err := strconv.ParseUint(checksum, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("p1: invalid checksum %q", checksum)
+		}
+		if crc16(telegram[:end+1]) != uint16(expected) {
+			return nil, ErrChecksum
+		}
+	} else if v := version(lines); v >= 40 {
+		return nil, fmt.Errorf("p1: missing checksum in DSMR %d telegram", v)
+	}
+
+	t := &Telegram{Identification: strings.TrimSpace(lines[0])}
+	var importTariffs, exportTariffs float64
+	for _, line := range lines[1:] {
+		obis, value, ok := parseLine(line)
+		if !ok {
+			continue
+		}
+		switch obis {
+		case "1-0:1.8.0":
+			t.ImportEnergy = value
+		case "1-0:1.8.1", "1-0:1.8.2":
+			importTariffs += value
+		case "1-0:2.8.0":
+			t.ExportEnergy = value
+		case "1-0:2.8.1", "1-0:2.8.2":
+			exportTariffs += value
+		case "1-0:1.7.0":
+			t.ImportPower = value
+		case "1-0:2.7.0":
+			t.ExportPower = value
+		case "1-0:21.7.0", "1-0:41.7.0", "1-0:61.7.0":
+			t.phase(obis).ImportPower = value
+		case "1-0:22.7.0", "1-0:42.7.0", "1-0:62.7.0":
+			t.phase(obis).ExportPower = value
+		case "1-0:32.7.0", "1-0:52.7.0", "1-0:72.7.0":
+			t.phase(obis).Voltage = value
+		case "1-0:31.7.0", "1-0:51.7.0", "1-0:71.7.0":
+			t.phase(obis).Current = value
+		}
+	}
+	// meters send either the total or the tariffs.
+	if t.ImportEnergy == 0 {
+		t.ImportEnergy = importTariffs
+	}
+	if t.ExportEnergy == 0 {
+		t.ExportEnergy = exportTariffs
+	}
+	return t, nil
+}
+
+// version returns the DSMR version of the 1-3:0.2.8 object times ten, e.g. 50 for DSMR 5.0. Belgian e-MUCS
+// telegrams carry the 0-0:96.1.4 object instead, its first two digits are the DSMR version. Both objects exist
+// since DSMR 4, an unparsable version is 40. It is zero if both are missing like in telegrams before DSMR 4.
+func version(lines []string) int {
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		value, ok := strings.CutPrefix(line, "1-3:0.2.8(")
+		if !ok {
+			value, ok = strings.CutPrefix(line, "0-0:96.1.4(")
+			if !ok {
+				continue
+			}
+			// e-MUCS is based on DSMR 5, e.g. 50217 for DSMR 5.0.2 e-MUCS 1.7
+			value = value[:min(2, len(value))]
+		}
+		v, err := strconv.Atoi(strings.TrimSuffix(value, ")"))
+		if err != nil {
+			return 40
+		}
+		return v
+	}
+	return 0
+}
+
+// phase returns the phase of the per phase object obis, 1-0:2x.7.0 is L1, 4x L2 and 6x L3 (3x, 5x, 7x
+// for voltage and current).
+func (t *Telegram) phase(obis string) *Phase {
+	i := (obis[4] - '2') / 2
+	for len(t.Phases) <= int(i) {
+		t.Phases = append(t.Phases, Phase{})
+	}
+	return &t.Phases[i]
+}
+
+// parseLine parses a line like 1-0:1.7.0(01.193*kW) and returns the value in W, Wh, V or A.
+// It returns false for lines without numeric value.
+func parseLine(line string) (obis string, value float64, ok bool) {
+	obis, rest, ok := strings.Cut(strings.TrimSpace(line), "(")
+	if !ok {
+		return "", 0, false
+	}
+	// objects with multiple values have the measurement in the last group.
+	start := strings.LastIndexByte(rest, '(')
+	rest = strings.TrimSuffix(rest[start+1:], ")")
+	number, unit, _ := strings.Cut(rest, "*")
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	switch unit {
+	case "kW", "kWh":
+		value *= 1000
+	case "W", "Wh", "V", "A":
+	default:
+		return "", 0, false
+	}
+	return obis, value, true
+}
+
+// crc16 is the CRC-16/ARC of the telegram from '/' to '!'.
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b)
+		for range 8 {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
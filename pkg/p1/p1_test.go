@@ -0,0 +1,96 @@
+package p1
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	be.NilErr(t, err)
+	return data
+}
+
+func inRange(expected, value float64) bool {
+	return value > expected-0.001 && value < expected+0.001
+}
+
+func TestParse(t *testing.T) {
+	t.Run(`dsmr 5`, func(t *testing.T) {
+		telegram, err := Parse(readFixture(t, "dsmr50.txt"))
+		be.NilErr(t, err)
+		be.Equal(t, `ISK5\2M550T-1012`, telegram.Identification)
+		be.True(t, inRange(-1250, telegram.Power()))
+		be.True(t, inRange(2000000, telegram.ImportEnergy))
+		be.True(t, inRange(150500, telegram.ExportEnergy))
+		be.Equal(t, 3, len(telegram.Phases))
+		be.True(t, inRange(-1900, telegram.Phases[0].Power()))
+		be.True(t, inRange(450, telegram.Phases[1].Power()))
+		be.True(t, inRange(200, telegram.Phases[2].Power()))
+		be.True(t, inRange(231.2, telegram.Phases[1].Voltage))
+		be.True(t, inRange(6, telegram.Phases[0].Current))
+	})
+	t.Run(`dsmr 2.2 without crc`, func(t *testing.T) {
+		telegram, err := Parse(readFixture(t, "dsmr22.txt"))
+		be.NilErr(t, err)
+		be.True(t, inRange(980, telegram.Power()))
+		be.True(t, inRange(269000, telegram.ImportEnergy))
+		be.Equal(t, 0, len(telegram.Phases))
+	})
+	t.Run(`checksum mismatch`, func(t *testing.T) {
+		data := readFixture(t, "dsmr50.txt")
+		data = bytes.Replace(data, []byte("01.250*kW"), []byte("01.251*kW"), 1)
+		_, err := Parse(data)
+		be.True(t, errors.Is(err, ErrChecksum))
+	})
+	t.Run(`dsmr 5 without crc`, func(t *testing.T) {
+		data := readFixture(t, "dsmr50.txt")
+		end := bytes.LastIndexByte(data, '!')
+		_, err := Parse(append(data[:end+1:end+1], "\r\n"...))
+		be.Nonzero(t, err)
+	})
+	t.Run(`e-MUCS without crc`, func(t *testing.T) {
+		data := readFixture(t, "emucs_nocrc.txt")
+		_, err := Parse(data)
+		be.Nonzero(t, err)
+		be.In(t, "missing checksum", err.Error())
+
+		end := bytes.LastIndexByte(data, '!')
+		data = fmt.Appendf(data[:end+1:end+1], "%04X\r\n", crc16(data[:end+1]))
+		telegram, err := Parse(data)
+		be.NilErr(t, err)
+		be.True(t, inRange(-840, telegram.Power()))
+		be.Equal(t, 3, len(telegram.Phases))
+	})
+	t.Run(`incomplete`, func(t *testing.T) {
+		data := readFixture(t, "dsmr50.txt")
+		_, err := Parse(data[:100])
+		be.Nonzero(t, err)
+	})
+}
+
+func TestReadTelegram(t *testing.T) {
+	dsmr50 := readFixture(t, "dsmr50.txt")
+	// starts in the middle of a telegram
+	input := bytes.Join([][]byte{dsmr50[200:], dsmr50, readFixture(t, "dsmr22.txt")}, nil)
+	r := NewReader(bytes.NewReader(input))
+
+	telegram, err := r.ReadTelegram()
+	be.NilErr(t, err)
+	be.Equal(t, string(dsmr50), string(telegram))
+
+	telegram, err = r.ReadTelegram()
+	be.NilErr(t, err)
+	_, err = Parse(telegram)
+	be.NilErr(t, err)
+
+	_, err = r.ReadTelegram()
+	be.True(t, errors.Is(err, io.EOF))
+}
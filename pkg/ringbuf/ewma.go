@@ -0,0 +1,34 @@
+package ringbuf
+
+import (
+	"math"
+	"time"
+)
+
+// EWMA is an exponentially weighted moving average with the time constant tau. The weight of a sample
+// depends on the time since the previous sample, so irregular intervals are handled.
+type EWMA struct {
+	tau   time.Duration
+	value float64
+	at    time.Time
+}
+
+func NewEWMA(tau time.Duration) *EWMA {
+	return &EWMA{tau: tau, value: math.NaN()}
+}
+
+// Add adds the value v sampled at time at.
+func (e *EWMA) Add(v float64, at time.Time) {
+	if e.at.IsZero() || e.tau <= 0 {
+		e.value = v
+	} else {
+		alpha := 1 - math.Exp(-at.Sub(e.at).Seconds()/e.tau.Seconds())
+		e.value += alpha * (v - e.value)
+	}
+	e.at = at
+}
+
+// Value returns the average, NaN if no value was added.
+func (e *EWMA) Value() float64 {
+	return e.value
+}
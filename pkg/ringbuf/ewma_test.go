@@ -0,0 +1,25 @@
+package ringbuf_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+
+	"github.com/yvesf/ve-ctrl-tool/pkg/ringbuf"
+)
+
+func TestEWMA(t *testing.T) {
+	start := time.Now()
+	e := ringbuf.NewEWMA(time.Second)
+	be.True(t, math.IsNaN(e.Value()))
+	e.Add(100, start)
+	be.Equal(t, float64(100), e.Value())
+	// after one time constant the average moved 63% towards the new value.
+	e.Add(200, start.Add(time.Second))
+	inRange(t, 163.2, e.Value(), 0.1)
+	// after a long gap the new value dominates.
+	e.Add(0, start.Add(time.Minute))
+	inRange(t, 0, e.Value(), 0.0001)
+}
@@ -1,5 +1,10 @@
 package ringbuf
 
+import (
+	"math"
+	"slices"
+)
+
 // Ringbuf is a simple circular buffer to calculate the average value of a series.
 type Ringbuf struct {
 	buf []float64
@@ -25,6 +30,11 @@ func (r *Ringbuf) Add(v float64) {
 	r.p = (r.p + 1) % r.s
 }
 
+// Len returns the number of values in the buffer.
+func (r *Ringbuf) Len() int {
+	return len(r.buf)
+}
+
 func (r *Ringbuf) Mean() float64 {
 	var sum float64
 	for _, v := range r.buf {
@@ -32,3 +42,64 @@ func (r *Ringbuf) Mean() float64 {
 	}
 	return sum / float64(len(r.buf))
 }
+
+// Median returns the middle value, the mean of the two middle values for an even number of values.
+// It is NaN if the buffer is empty.
+func (r *Ringbuf) Median() float64 {
+	if len(r.buf) == 0 {
+		return math.NaN()
+	}
+	sorted := r.sorted()
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// TrimmedMean returns the mean without the fraction trim (0..0.5) of the lowest and of the highest values.
+// It is NaN if the buffer is empty.
+func (r *Ringbuf) TrimmedMean(trim float64) float64 {
+	sorted := r.sorted()
+	k := int(float64(len(sorted)) * min(max(trim, 0), 0.5))
+	if 2*k >= len(sorted) {
+		return r.Median()
+	}
+	var sum float64
+	for _, v := range sorted[k : len(sorted)-k] {
+		sum += v
+	}
+	return sum / float64(len(sorted)-2*k)
+}
+
+// Min returns the lowest value, NaN if the buffer is empty.
+func (r *Ringbuf) Min() float64 {
+	if len(r.buf) == 0 {
+		return math.NaN()
+	}
+	return slices.Min(r.buf)
+}
+
+// Max returns the highest value, NaN if the buffer is empty.
+func (r *Ringbuf) Max() float64 {
+	if len(r.buf) == 0 {
+		return math.NaN()
+	}
+	return slices.Max(r.buf)
+}
+
+// StdDev returns the population standard deviation, NaN if the buffer is empty.
+func (r *Ringbuf) StdDev() float64 {
+	mean := r.Mean()
+	var sum float64
+	for _, v := range r.buf {
+		sum += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sum / float64(len(r.buf)))
+}
+
+func (r *Ringbuf) sorted() []float64 {
+	sorted := slices.Clone(r.buf)
+	slices.Sort(sorted)
+	return sorted
+}
@@ -61,3 +61,40 @@ func TestRingbuf(t *testing.T) {
 		inRange(t, float64(-19.2), r.Mean(), 0.0001)
 	})
 }
+
+func TestRingbuf_statistics(t *testing.T) {
+	t.Run(`empty`, func(t *testing.T) {
+		r := ringbuf.NewRingbuf(5)
+		be.Equal(t, 0, r.Len())
+		be.True(t, math.IsNaN(r.Median()))
+		be.True(t, math.IsNaN(r.TrimmedMean(0.2)))
+		be.True(t, math.IsNaN(r.Min()))
+		be.True(t, math.IsNaN(r.Max()))
+		be.True(t, math.IsNaN(r.StdDev()))
+	})
+	t.Run(`spike`, func(t *testing.T) {
+		r := ringbuf.NewRingbuf(5)
+		for _, v := range []float64{90, 110, 2100, 100, 100} {
+			r.Add(v)
+		}
+		be.Equal(t, 5, r.Len())
+		be.Equal(t, float64(100), r.Median())
+		inRange(t, 103.333, r.TrimmedMean(0.2), 0.001)
+		be.Equal(t, float64(90), r.Min())
+		be.Equal(t, float64(2100), r.Max())
+		inRange(t, 800, r.StdDev(), 0.5)
+		// the oldest value is replaced
+		r.Add(2000)
+		be.Equal(t, float64(100), r.Min())
+		be.Equal(t, float64(110), r.Median())
+	})
+	t.Run(`even number`, func(t *testing.T) {
+		r := ringbuf.NewRingbuf(4)
+		for _, v := range []float64{4, 1, 3, 2} {
+			r.Add(v)
+		}
+		be.Equal(t, 2.5, r.Median())
+		be.Equal(t, 2.5, r.TrimmedMean(0.5))
+		be.Equal(t, 2.5, r.TrimmedMean(0))
+	})
+}
@@ -0,0 +1,57 @@
+package ringbuf
+
+import (
+	"math"
+	"time"
+)
+
+// TimeWindow calculates the average of the samples within a time window. Each sample is weighted by the
+// time since the previous sample, so after a gap in the series old samples are out of the window and
+// frequent samples do not outweigh rare ones.
+type TimeWindow struct {
+	window  time.Duration
+	samples []sample
+}
+
+type sample struct {
+	v  float64
+	at time.Time
+}
+
+func NewTimeWindow(window time.Duration) *TimeWindow {
+	return &TimeWindow{window: window}
+}
+
+// Add adds the value v sampled at time at. Samples must be added in chronological order.
+func (w *TimeWindow) Add(v float64, at time.Time) {
+	w.samples = append(w.samples, sample{v: v, at: at})
+	// samples at or before the start of the window are not used by Mean, the interval of the first sample
+	// in the window starts at the window start.
+	start := at.Add(-w.window)
+	i := 0
+	for i < len(w.samples) && !w.samples[i].at.After(start) {
+		i++
+	}
+	w.samples = w.samples[i:]
+}
+
+// Mean returns the weighted average of the samples within the window ending at now. It is NaN if there is
+// no sample in the window.
+func (w *TimeWindow) Mean(now time.Time) float64 {
+	start := now.Add(-w.window)
+	var sum, total float64
+	from := start
+	for _, s := range w.samples {
+		if !s.at.After(start) {
+			continue
+		}
+		weight := s.at.Sub(from).Seconds()
+		sum += s.v * weight
+		total += weight
+		from = s.at
+	}
+	if total == 0 {
+		return math.NaN()
+	}
+	return sum / total
+}
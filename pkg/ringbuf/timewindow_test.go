@@ -0,0 +1,46 @@
+package ringbuf_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+
+	"github.com/yvesf/ve-ctrl-tool/pkg/ringbuf"
+)
+
+func TestTimeWindow(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	t.Run(`empty`, func(t *testing.T) {
+		w := ringbuf.NewTimeWindow(time.Second)
+		be.True(t, math.IsNaN(w.Mean(start)))
+	})
+	t.Run(`weighted by interval`, func(t *testing.T) {
+		w := ringbuf.NewTimeWindow(4 * time.Second)
+		w.Add(100, at(0))
+		w.Add(200, at(3000)) // covers 3s
+		w.Add(600, at(4000)) // covers 1s
+		// window 0..4s: 100 covers nothing within the window, 200 3s and 600 1s.
+		inRange(t, 300, w.Mean(at(4000)), 0.0001)
+	})
+	t.Run(`old samples after a gap`, func(t *testing.T) {
+		w := ringbuf.NewTimeWindow(4 * time.Second)
+		for i := range 5 {
+			w.Add(1000, at(i*800))
+		}
+		w.Add(100, at(20000))
+		be.Equal(t, float64(100), w.Mean(at(20000)))
+		be.True(t, math.IsNaN(w.Mean(at(30000))))
+	})
+	t.Run(`window start within an interval`, func(t *testing.T) {
+		w := ringbuf.NewTimeWindow(time.Second)
+		w.Add(0, at(0))
+		w.Add(100, at(1000))
+		w.Add(300, at(1500))
+		// window 500..1500: 100 covers 500ms, 300 covers 500ms.
+		inRange(t, 200, w.Mean(at(1500)), 0.0001)
+	})
+}
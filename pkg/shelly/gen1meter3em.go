@@ -0,0 +1,69 @@
+package shelly
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Gen1Meter reads the original (Gen1) Shelly 3EM using the /status endpoint.
+type Gen1Meter struct {
+	Client *http.Client
+	Addr   string
+}
+
+// Gen1EMeter is the reading of one phase of the Shelly 3EM.
+type Gen1EMeter struct {
+	// Active power of the phase.
+	// Positive values is power taken from the grid/uplink.
+	// Negative values is power injected to the grid/uplink.
+	Power       float64 `json:"power"`
+	PowerFactor float64 `json:"pf"`
+	Current     float64 `json:"current"`
+	Voltage     float64 `json:"voltage"`
+	IsValid     bool    `json:"is_valid"`
+	// Energy counters in Wh.
+	Total         float64 `json:"total"`
+	TotalReturned float64 `json:"total_returned"`
+}
+
+type Gen1MeterData struct {
+	// EMeters has one entry per phase.
+	EMeters []Gen1EMeter `json:"emeters"`
+}
+
+// TotalPower returns the sum of the active power on all phases.
+func (d Gen1MeterData) TotalPower() float64 {
+	var sum float64
+	for _, e := range d.EMeters {
+		sum += e.Power
+	}
+	return sum
+}
+
+// Read returns the emeters status of the Shelly 3EM. It fails if the device reports any phase as invalid.
+func (s Gen1Meter) Read(ctx context.Context) (*Gen1MeterData, error) {
+	url := url.URL{
+		Scheme: "http",
+		Host:   s.Addr,
+		Path:   "/status",
+	}
+
+	data := new(Gen1MeterData)
+	err := getJSON(ctx, s.Client, url, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data.EMeters) == 0 {
+		return nil, fmt.Errorf("shelly device returned no emeters")
+	}
+	for i, e := range data.EMeters {
+		if !e.IsValid {
+			return nil, fmt.Errorf("shelly device reports invalid measurement for emeter %d", i)
+		}
+	}
+
+	return data, nil
+}
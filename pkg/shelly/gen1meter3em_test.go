@@ -0,0 +1,63 @@
+package shelly
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+// gen1Status is a shortened /status response of a Shelly 3EM in the format of firmware 1.14.
+const gen1Status = `{"wifi_sta":{"connected":true,"ssid":"iot","ip":"10.1.0.211","rssi":-63},` +
+	`"cloud":{"enabled":false,"connected":false},"mqtt":{"connected":false},` +
+	`"time":"13:37","unixtime":1700000000,"serial":2201,"has_update":false,"mac":"C45BBE6A1B2C",` +
+	`"relays":[{"ison":false,"has_timer":false,"timer_started":0,"timer_duration":0,` +
+	`"timer_remaining":0,"overpower":false,"is_valid":true,"source":"input"}],` +
+	`"emeters":[` +
+	`{"power":136.70,"pf":0.73,"current":0.95,"voltage":229.70,"is_valid":true,` +
+	`"total":1234567.8,"total_returned":12.3},` +
+	`{"power":-81.80,"pf":-0.63,"current":0.87,"voltage":230.50,"is_valid":true,` +
+	`"total":765432.1,"total_returned":456.7},` +
+	`{"power":836.40,"pf":0.74,"current":5.50,"voltage":233.70,"is_valid":true,` +
+	`"total":2345678.9,"total_returned":0.0}],` +
+	`"total_power":891.30,"fs_mounted":true,"uptime":123456}`
+
+func TestGen1Meter(t *testing.T) {
+	serve := func(body string) string {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			be.Equal(t, "/status", r.URL.Path)
+			wr := bufio.NewWriter(w)
+			_, _ = wr.WriteString(body)
+			wr.Flush()
+		}))
+		t.Cleanup(server.Close)
+		u, _ := url.Parse(server.URL)
+		return u.Host
+	}
+
+	t.Run(`ok`, func(t *testing.T) {
+		shelly := Gen1Meter{Addr: serve(gen1Status), Client: http.DefaultClient}
+		d, err := shelly.Read(context.Background())
+		be.NilErr(t, err)
+
+		be.Equal(t, 3, len(d.EMeters))
+		be.Equal(t, 891.3, d.TotalPower())
+		be.Equal(t, -81.8, d.EMeters[1].Power)
+		be.Equal(t, 233.7, d.EMeters[2].Voltage)
+	})
+	t.Run(`invalid phase`, func(t *testing.T) {
+		body := `{"emeters":[{"power":1,"is_valid":true},{"power":0,"is_valid":false},{"power":1,"is_valid":true}]}`
+		shelly := Gen1Meter{Addr: serve(body), Client: http.DefaultClient}
+		_, err := shelly.Read(context.Background())
+		be.Nonzero(t, err)
+	})
+	t.Run(`no emeters`, func(t *testing.T) {
+		shelly := Gen1Meter{Addr: serve(`{"relays":[]}`), Client: http.DefaultClient}
+		_, err := shelly.Read(context.Background())
+		be.Nonzero(t, err)
+	})
+}
@@ -1,60 +1,145 @@
 package shelly
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 )
 
+// Gen2Meter reads three phase Shelly Gen2 meters with an EM component (Shelly Pro 3EM).
 type Gen2Meter struct {
 	Client *http.Client
 	Addr   string
+	// ID of the EM component, 0 on most devices.
+	ID int
+}
+
+// Gen2MeterPhase is the reading of a single phase of the EM component.
+type Gen2MeterPhase struct {
+	Current float64
+	Voltage float64
+	// Active power of the phase.
+	// Positive values is power taken from the grid/uplink.
+	// Negative values is power injected to the grid/uplink.
+	ActivePower   float64
+	ApparentPower float64
+	PowerFactor   float64
 }
 
 type Gen2MeterData struct {
+	ID int
+	// Phases A, B and C.
+	Phases [3]Gen2MeterPhase
+	// NeutralCurrent is nil if the device has no current transformer on the neutral line.
+	NeutralCurrent *float64
+	TotalCurrent   float64
 	// Sum of the active power on all phases.
 	// Positive values is power taken from the grid/uplink.
 	// Negative values is power injected to the grid/uplink.
-	TotalPowerFloat float64 `json:"total_act_power"`
+	TotalPowerFloat    float64
+	TotalApparentPower float64
 }
 
 func (d Gen2MeterData) TotalPower() float64 {
 	return d.TotalPowerFloat
 }
 
-// Read returns the whole Shelly3EMData status update from the Shelly 3EM.
-func (s Gen2Meter) Read() (*Gen2MeterData, error) {
-	url := url.URL{
-		Scheme:   "http",
-		Host:     s.Addr,
-		Path:     "/rpc/EM.GetStatus",
-		RawQuery: "id=0",
-	}
+// gen2MeterDataJSON is the EM.GetStatus response document.
+type gen2MeterDataJSON struct {
+	ID             int      `json:"id"`
+	ACurrent       float64  `json:"a_current"`
+	AVoltage       float64  `json:"a_voltage"`
+	AActPower      float64  `json:"a_act_power"`
+	AAprtPower     float64  `json:"a_aprt_power"`
+	APF            float64  `json:"a_pf"`
+	BCurrent       float64  `json:"b_current"`
+	BVoltage       float64  `json:"b_voltage"`
+	BActPower      float64  `json:"b_act_power"`
+	BAprtPower     float64  `json:"b_aprt_power"`
+	BPF            float64  `json:"b_pf"`
+	CCurrent       float64  `json:"c_current"`
+	CVoltage       float64  `json:"c_voltage"`
+	CActPower      float64  `json:"c_act_power"`
+	CAprtPower     float64  `json:"c_aprt_power"`
+	CPF            float64  `json:"c_pf"`
+	NCurrent       *float64 `json:"n_current"`
+	TotalCurrent   float64  `json:"total_current"`
+	TotalActPower  float64  `json:"total_act_power"`
+	TotalAprtPower float64  `json:"total_aprt_power"`
+}
 
-	req, err := http.NewRequest(http.MethodGet, url.String(), nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to construct request: %w", err)
+func (d *Gen2MeterData) UnmarshalJSON(b []byte) error {
+	// start from the current values, this allows applying partial status updates.
+	doc := d.toJSON()
+	if doc.NCurrent != nil {
+		// decode into a new value, the pointer is shared with copies of d handed out before.
+		neutral := *doc.NCurrent
+		doc.NCurrent = &neutral
 	}
-	resp, err := s.Client.Do(req)
+	err := json.Unmarshal(b, &doc)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read from shelly device: %w", err)
+		return err
 	}
-	defer resp.Body.Close()
+	*d = Gen2MeterData{
+		ID: doc.ID,
+		Phases: [3]Gen2MeterPhase{
+			{doc.ACurrent, doc.AVoltage, doc.AActPower, doc.AAprtPower, doc.APF},
+			{doc.BCurrent, doc.BVoltage, doc.BActPower, doc.BAprtPower, doc.BPF},
+			{doc.CCurrent, doc.CVoltage, doc.CActPower, doc.CAprtPower, doc.CPF},
+		},
+		NeutralCurrent:     doc.NCurrent,
+		TotalCurrent:       doc.TotalCurrent,
+		TotalPowerFloat:    doc.TotalActPower,
+		TotalApparentPower: doc.TotalAprtPower,
+	}
+	return nil
+}
+
+func (d Gen2MeterData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.toJSON())
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code from shelly device: %v", resp.StatusCode)
+func (d Gen2MeterData) toJSON() gen2MeterDataJSON {
+	a, b, c := d.Phases[0], d.Phases[1], d.Phases[2]
+	return gen2MeterDataJSON{
+		ID:             d.ID,
+		ACurrent:       a.Current,
+		AVoltage:       a.Voltage,
+		AActPower:      a.ActivePower,
+		AAprtPower:     a.ApparentPower,
+		APF:            a.PowerFactor,
+		BCurrent:       b.Current,
+		BVoltage:       b.Voltage,
+		BActPower:      b.ActivePower,
+		BAprtPower:     b.ApparentPower,
+		BPF:            b.PowerFactor,
+		CCurrent:       c.Current,
+		CVoltage:       c.Voltage,
+		CActPower:      c.ActivePower,
+		CAprtPower:     c.ApparentPower,
+		CPF:            c.PowerFactor,
+		NCurrent:       d.NeutralCurrent,
+		TotalCurrent:   d.TotalCurrent,
+		TotalActPower:  d.TotalPowerFloat,
+		TotalAprtPower: d.TotalApparentPower,
 	}
+}
 
-	// we expect no valid response larger than 1mb
-	bodyReader := io.LimitReader(resp.Body, 1024*1024)
+// Read returns the whole Shelly3EMData status update from the Shelly 3EM.
+func (s Gen2Meter) Read(ctx context.Context) (*Gen2MeterData, error) {
+	url := url.URL{
+		Scheme:   "http",
+		Host:     s.Addr,
+		Path:     "/rpc/EM.GetStatus",
+		RawQuery: url.Values{"id": {strconv.Itoa(s.ID)}}.Encode(),
+	}
 
 	data := new(Gen2MeterData)
-	d := json.NewDecoder(bodyReader)
-	err = d.Decode(data)
+	err := getJSON(ctx, s.Client, url, data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse response from shelly device: %w", err)
+		return nil, err
 	}
 
 	return data, nil
@@ -0,0 +1,72 @@
+package shelly
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Gen2EM1Meter reads single phase Shelly Gen2 meters with EM1 components (Shelly Pro EM, EM Mini Gen3).
+// Each EM1 component is one measurement channel (clamp).
+type Gen2EM1Meter struct {
+	Client *http.Client
+	Addr   string
+	// Channels are the ids of the EM1 components to read.
+	Channels []int
+}
+
+// Gen2EM1ChannelData is the EM1.GetStatus response of one channel.
+type Gen2EM1ChannelData struct {
+	ID      int     `json:"id"`
+	Current float64 `json:"current"`
+	Voltage float64 `json:"voltage"`
+	// Active power of the channel.
+	// Positive values is power taken from the grid/uplink.
+	// Negative values is power injected to the grid/uplink.
+	ActivePower   float64 `json:"act_power"`
+	ApparentPower float64 `json:"aprt_power"`
+	PowerFactor   float64 `json:"pf"`
+	Frequency     float64 `json:"freq"`
+}
+
+type Gen2EM1MeterData struct {
+	// Channels in the order of Gen2EM1Meter.Channels.
+	Channels []Gen2EM1ChannelData
+}
+
+// TotalPower returns the sum of the active power of all channels.
+func (d Gen2EM1MeterData) TotalPower() float64 {
+	var sum float64
+	for _, c := range d.Channels {
+		sum += c.ActivePower
+	}
+	return sum
+}
+
+// Read returns the status of all configured channels.
+func (s Gen2EM1Meter) Read(ctx context.Context) (*Gen2EM1MeterData, error) {
+	if len(s.Channels) == 0 {
+		return nil, fmt.Errorf("no channels configured")
+	}
+
+	data := new(Gen2EM1MeterData)
+	for _, id := range s.Channels {
+		url := url.URL{
+			Scheme:   "http",
+			Host:     s.Addr,
+			Path:     "/rpc/EM1.GetStatus",
+			RawQuery: url.Values{"id": {strconv.Itoa(id)}}.Encode(),
+		}
+
+		var channel Gen2EM1ChannelData
+		err := getJSON(ctx, s.Client, url, &channel)
+		if err != nil {
+			return nil, fmt.Errorf("channel %d: %w", id, err)
+		}
+		data.Channels = append(data.Channels, channel)
+	}
+
+	return data, nil
+}
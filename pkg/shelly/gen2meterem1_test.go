@@ -0,0 +1,71 @@
+package shelly
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestGen2EM1Meter(t *testing.T) {
+	// responses of a Shelly Pro EM with the grid on channel 0 and the PV inverter on channel 1.
+	channels := map[string]string{
+		"0": `{"id":0,"current":2.134,"voltage":231.2,"act_power":-412.3,"aprt_power":493.4,"pf":-0.84,` +
+			`"freq":50.0,"calibration":"factory"}`,
+		"1": `{"id":1,"current":3.911,"voltage":231.2,"act_power":-897.5,"aprt_power":904.2,"pf":-0.99,` +
+			`"freq":50.0,"calibration":"factory"}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rpc/EM1.GetStatus" {
+			http.NotFound(w, r)
+			return
+		}
+		body, ok := channels[r.URL.Query().Get("id")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+	url, _ := url.Parse(server.URL)
+
+	t.Run(`single channel`, func(t *testing.T) {
+		shelly := Gen2EM1Meter{Addr: url.Host, Client: http.DefaultClient, Channels: []int{0}}
+		d, err := shelly.Read(context.Background())
+		be.NilErr(t, err)
+		be.Equal(t, -412.3, d.TotalPower())
+		be.Equal(t, 1, len(d.Channels))
+		be.Equal(t, Gen2EM1ChannelData{
+			ID: 0, Current: 2.134, Voltage: 231.2, ActivePower: -412.3, ApparentPower: 493.4, PowerFactor: -0.84,
+			Frequency: 50,
+		}, d.Channels[0])
+	})
+	t.Run(`sum of channels`, func(t *testing.T) {
+		shelly := Gen2EM1Meter{Addr: url.Host, Client: http.DefaultClient, Channels: []int{0, 1}}
+		d, err := shelly.Read(context.Background())
+		be.NilErr(t, err)
+		inRange(t, -1309.8, d.TotalPower(), 0.0001)
+	})
+	t.Run(`unknown channel`, func(t *testing.T) {
+		shelly := Gen2EM1Meter{Addr: url.Host, Client: http.DefaultClient, Channels: []int{0, 2}}
+		_, err := shelly.Read(context.Background())
+		be.Nonzero(t, err)
+	})
+	t.Run(`no channels`, func(t *testing.T) {
+		shelly := Gen2EM1Meter{Addr: url.Host, Client: http.DefaultClient}
+		_, err := shelly.Read(context.Background())
+		be.Nonzero(t, err)
+	})
+}
+
+func inRange(t *testing.T, expected, got, delta float64) {
+	t.Helper()
+	if got < expected-delta || got > expected+delta {
+		t.Fatalf("%f not in %f+/-%f", got, expected, delta)
+	}
+}
@@ -2,6 +2,8 @@ package shelly
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -25,15 +27,72 @@ func TestGen2Meter(t *testing.T) {
 
 	url, _ := url.Parse(server.URL)
 	shelly := Gen2Meter{Addr: url.Host, Client: http.DefaultClient}
-	d, err := shelly.Read()
+	d, err := shelly.Read(context.Background())
 	be.NilErr(t, err)
 
 	be.Equal(t, 1054.962, d.TotalPower())
+	be.Equal(t, Gen2MeterPhase{
+		Current: 0.951, Voltage: 229.7, ActivePower: 136.7, ApparentPower: 218.4, PowerFactor: -0.73,
+	}, d.Phases[0])
+	be.Equal(t, Gen2MeterPhase{
+		Current: 0.867, Voltage: 230.5, ActivePower: 81.8, ApparentPower: 199.8, PowerFactor: -0.63,
+	}, d.Phases[1])
+	be.Equal(t, Gen2MeterPhase{
+		Current: 5.495, Voltage: 233.7, ActivePower: 836.4, ApparentPower: 1282.3, PowerFactor: -0.74,
+	}, d.Phases[2])
+	be.True(t, d.NeutralCurrent == nil)
+	be.Equal(t, 7.313, d.TotalCurrent)
+	be.Equal(t, 1700.496, d.TotalApparentPower)
+}
+
+func TestGen2Meter_componentID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		be.Equal(t, "/rpc/EM.GetStatus", r.URL.Path)
+		be.Equal(t, "1", r.URL.Query().Get("id"))
+		_, _ = fmt.Fprint(w, `{"id":1,"total_act_power":-5.5}`)
+	}))
+	defer server.Close()
+
+	url, _ := url.Parse(server.URL)
+	shelly := Gen2Meter{Addr: url.Host, Client: http.DefaultClient, ID: 1}
+	d, err := shelly.Read(context.Background())
+	be.NilErr(t, err)
+	be.Equal(t, 1, d.ID)
+	be.Equal(t, -5.5, d.TotalPower())
+}
+
+func TestGen2MeterData_JSON(t *testing.T) {
+	neutral := 0.5
+	d := Gen2MeterData{
+		Phases:          [3]Gen2MeterPhase{{Voltage: 230}, {Voltage: 231}, {Voltage: 232, ActivePower: 10}},
+		NeutralCurrent:  &neutral,
+		TotalPowerFloat: 10,
+	}
+	b, err := json.Marshal(d)
+	be.NilErr(t, err)
+
+	var decoded Gen2MeterData
+	be.NilErr(t, json.Unmarshal(b, &decoded))
+	be.Equal(t, d.Phases, decoded.Phases)
+	be.Equal(t, 0.5, *decoded.NeutralCurrent)
+
+	t.Run(`partial update`, func(t *testing.T) {
+		be.NilErr(t, json.Unmarshal([]byte(`{"b_voltage":0,"total_act_power":12}`), &decoded))
+		be.Equal(t, 230.0, decoded.Phases[0].Voltage)
+		be.Equal(t, 0.0, decoded.Phases[1].Voltage)
+		be.Equal(t, 12.0, decoded.TotalPower())
+	})
+	t.Run(`update keeps earlier copies`, func(t *testing.T) {
+		first := decoded
+		be.NilErr(t, json.Unmarshal([]byte(`{"n_current":0.7}`), &decoded))
+		be.Equal(t, 0.7, *decoded.NeutralCurrent)
+		be.Equal(t, 0.5, *first.NeutralCurrent)
+	})
 }
 
 func ExampleGen2Meter() {
 	m := Gen2Meter{Addr: "shellypro3em-0cb815fc53bc", Client: http.DefaultClient}
-	data, err := m.Read()
+	data, err := m.Read(context.Background())
 	if err != nil {
 		panic(err)
 	}
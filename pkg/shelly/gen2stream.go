@@ -0,0 +1,222 @@
+package shelly
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/yvesf/ve-ctrl-tool/pkg/websocket"
+)
+
+// Gen2Stream receives the status of the EM component over the websocket RPC channel of Shelly Gen2 devices.
+// The device pushes a NotifyStatus event on every change, this is faster than polling with Gen2Meter.
+type Gen2Stream struct {
+	Addr string
+	// ID of the EM component, 0 on most devices.
+	ID int
+	// Password of the device, empty if the device is not password protected.
+	Password string
+}
+
+// rpcFrame is a request, response or notification on the websocket RPC channel.
+type rpcFrame struct {
+	ID     int             `json:"id,omitempty"`
+	Src    string          `json:"src,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Auth   *rpcAuth        `json:"auth,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// rpcAuth is the digest authentication of a request, see
+// https://shelly-api-docs.shelly.cloud/gen2/General/Authentication.
+type rpcAuth struct {
+	Realm     string `json:"realm"`
+	Username  string `json:"username"`
+	Nonce     uint64 `json:"nonce"`
+	CNonce    uint64 `json:"cnonce"`
+	Response  string `json:"response"`
+	Algorithm string `json:"algorithm"`
+}
+
+// rpcChallenge is the JSON document in the message of an 401 error response.
+type rpcChallenge struct {
+	Realm     string `json:"realm"`
+	Nonce     uint64 `json:"nonce"`
+	NC        int    `json:"nc"`
+	Algorithm string `json:"algorithm"`
+}
+
+const (
+	// rpcSource identifies this client to the device. Notifications are only sent to clients that have
+	// sent a request with a source.
+	rpcSource = "ve-ctrl-tool"
+	// streamRefreshInterval is the interval of repeating EM.GetStatus. The device only notifies on change,
+	// the refresh keeps the status current and detects a dead connection.
+	streamRefreshInterval = 5 * time.Second
+)
+
+// Run connects to the device and calls fn with the status after every EM.GetStatus response and
+// NotifyStatus event. It blocks until ctx is cancelled (returns nil) or the connection fails.
+func (s Gen2Stream) Run(ctx context.Context, fn func(Gen2MeterData)) error {
+	conn, err := websocket.Dial(ctx, "ws://"+s.Addr+"/rpc")
+	if err != nil {
+		return err
+	}
+
+	params, _ := json.Marshal(map[string]int{"id": s.ID})
+	request := rpcFrame{ID: 1, Src: rpcSource, Method: "EM.GetStatus", Params: params}
+	var (
+		currentRequest atomic.Pointer[rpcFrame] // the request including authentication
+		lastReceived   atomic.Int64
+		timedOut       atomic.Bool
+	)
+	initial := request
+	currentRequest.Store(&initial)
+	lastReceived.Store(time.Now().UnixNano())
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer conn.Close()
+		t := time.NewTicker(streamRefreshInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			case <-t.C:
+				if time.Since(time.Unix(0, lastReceived.Load())) > 2*streamRefreshInterval {
+					timedOut.Store(true)
+					return
+				}
+				_ = s.send(conn, *currentRequest.Load())
+			}
+		}
+	}()
+
+	err = s.send(conn, request)
+	if err != nil {
+		return err
+	}
+
+	component := "em:" + strconv.Itoa(s.ID)
+	var data Gen2MeterData
+	initialized := false
+	for {
+		message, err := conn.ReadMessage()
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case timedOut.Load():
+			return fmt.Errorf("no message received within %v", 2*streamRefreshInterval)
+		case err != nil:
+			return fmt.Errorf("failed to read from websocket: %w", err)
+		}
+		lastReceived.Store(time.Now().UnixNano())
+
+		var frame rpcFrame
+		err = json.Unmarshal(message, &frame)
+		if err != nil {
+			return fmt.Errorf("failed to decode rpc frame: %w", err)
+		}
+
+		switch {
+		case frame.Error != nil && frame.Error.Code == 401 && s.Password != "":
+			// every challenge is answered, the device sends a new one on nonce rollover.
+			request.Auth, err = s.authenticate(frame.Error.Message, request.Auth)
+			if err != nil {
+				return err
+			}
+			authenticated := request
+			currentRequest.Store(&authenticated)
+			err = s.send(conn, request)
+			if err != nil {
+				return err
+			}
+		case frame.Error != nil:
+			return fmt.Errorf("rpc error %d: %s", frame.Error.Code, frame.Error.Message)
+		case frame.ID == request.ID && frame.Result != nil:
+			err = json.Unmarshal(frame.Result, &data)
+			if err != nil {
+				return fmt.Errorf("failed to decode status: %w", err)
+			}
+			initialized = true
+			fn(data)
+		case frame.Method == "NotifyStatus" && initialized:
+			var status map[string]json.RawMessage
+			err = json.Unmarshal(frame.Params, &status)
+			if err != nil {
+				return fmt.Errorf("failed to decode notification: %w", err)
+			}
+			update, ok := status[component]
+			if !ok {
+				continue
+			}
+			// notifications only contain the changed fields.
+			err = json.Unmarshal(update, &data)
+			if err != nil {
+				return fmt.Errorf("failed to decode status: %w", err)
+			}
+			fn(data)
+		}
+	}
+}
+
+func (s Gen2Stream) send(conn *websocket.Conn, frame rpcFrame) error {
+	message, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	err = conn.WriteMessage(message)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	return nil
+}
+
+// authenticate answers the digest challenge in the message of an 401 error. previous is the rejected
+// authentication, a challenge with the same nonce means the password is wrong.
+func (s Gen2Stream) authenticate(message string, previous *rpcAuth) (*rpcAuth, error) {
+	var c rpcChallenge
+	err := json.Unmarshal([]byte(message), &c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode auth challenge: %w", err)
+	}
+	if c.Algorithm != "SHA-256" {
+		return nil, errors.New("unsupported digest algorithm " + strconv.Quote(c.Algorithm))
+	}
+	if previous != nil && previous.Nonce == c.Nonce {
+		return nil, errors.New("authentication failed, wrong password")
+	}
+
+	var b [4]byte
+	_, _ = io.ReadFull(cnonceSource, b[:])
+	cnonce := uint64(binary.BigEndian.Uint32(b[:]))
+
+	ha1 := sha256Hex("admin:" + c.Realm + ":" + s.Password)
+	ha2 := sha256Hex("dummy_method:dummy_uri")
+	response := sha256Hex(fmt.Sprintf("%s:%d:%d:%d:auth:%s", ha1, c.Nonce, c.NC, cnonce, ha2))
+	return &rpcAuth{
+		Realm:     c.Realm,
+		Username:  "admin",
+		Nonce:     c.Nonce,
+		CNonce:    cnonce,
+		Response:  response,
+		Algorithm: "SHA-256",
+	}, nil
+}
@@ -0,0 +1,146 @@
+package shelly
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+
+	"github.com/yvesf/ve-ctrl-tool/pkg/websocket"
+)
+
+// streamDevice emulates the websocket RPC channel of a Shelly Pro 3EM. It answers EM.GetStatus and
+// then sends the notifications. With a password, the first rollovers valid authentications are answered
+// with a challenge with a new nonce.
+func streamDevice(t *testing.T, password string, rollovers int, notifications ...string) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		nonce := uint64(1625038776)
+		cnonces := map[uint64]bool{}
+		for {
+			message, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			var req rpcFrame
+			err = json.Unmarshal(message, &req)
+			if err != nil || req.Method != "EM.GetStatus" || string(req.Params) != `{"id":0}` {
+				t.Errorf("unexpected request %s", message)
+				return
+			}
+
+			if password != "" {
+				valid := req.Auth != nil && validAuth(*req.Auth, password, nonce)
+				if valid {
+					if cnonces[req.Auth.CNonce] {
+						t.Errorf("cnonce %d reused", req.Auth.CNonce)
+					}
+					cnonces[req.Auth.CNonce] = true
+				}
+				if valid && rollovers > 0 {
+					rollovers--
+					nonce++
+					valid = false
+				}
+				if !valid {
+					challenge, _ := json.Marshal(fmt.Sprintf(`{"auth_type": "digest", "nonce": %d, "nc": 1, `+
+						`"realm": "shellypro3em", "algorithm": "SHA-256"}`, nonce))
+					_ = c.WriteMessage([]byte(fmt.Sprintf(`{"id":%d,"src":"shellypro3em","dst":%q,"error":`+
+						`{"code":401,"message":%s}}`, req.ID, req.Src, challenge)))
+					continue
+				}
+			}
+
+			_ = c.WriteMessage([]byte(fmt.Sprintf(`{"id":%d,"src":"shellypro3em","dst":%q,"result":`+
+				`{"id":0,"a_voltage":230.1,"a_act_power":10,"total_act_power":30}}`, req.ID, req.Src)))
+			for _, n := range notifications {
+				_ = c.WriteMessage([]byte(`{"src":"shellypro3em","dst":"ve-ctrl-tool","method":"NotifyStatus",` +
+					`"params":` + n + `}`))
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	u, _ := url.Parse(server.URL)
+	return u.Host
+}
+
+// validAuth checks the authentication independent of the implementation in gen2stream.go.
+func validAuth(a rpcAuth, password string, nonce uint64) bool {
+	hash := func(s string) string { return fmt.Sprintf("%x", sha256.Sum256([]byte(s))) }
+	ha1 := hash("admin:" + a.Realm + ":" + password)
+	ha2 := hash("dummy_method:dummy_uri")
+	return a.Nonce == nonce && a.Response == hash(fmt.Sprintf("%s:%d:1:%d:auth:%s", ha1, a.Nonce, a.CNonce, ha2))
+}
+
+// collect runs the stream until n updates are received.
+func collect(s Gen2Stream, n int) ([]Gen2MeterData, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var updates []Gen2MeterData
+	err := s.Run(ctx, func(d Gen2MeterData) {
+		updates = append(updates, d)
+		if len(updates) == n {
+			cancel()
+		}
+	})
+	return updates, err
+}
+
+func TestGen2Stream(t *testing.T) {
+	t.Run(`status and notifications`, func(t *testing.T) {
+		addr := streamDevice(t, "", 0,
+			`{"ts":1.5,"em:0":{"id":0,"a_act_power":-50,"total_act_power":-30}}`,
+			`{"ts":2.5,"em1:0":{"id":0,"act_power":1}}`,
+			`{"ts":3.5,"em:0":{"id":0,"total_act_power":5}}`)
+
+		updates, err := collect(Gen2Stream{Addr: addr}, 3)
+		be.NilErr(t, err)
+		be.Equal(t, 3, len(updates))
+		be.Equal(t, 30.0, updates[0].TotalPower())
+		be.Equal(t, -30.0, updates[1].TotalPower())
+		be.Equal(t, -50.0, updates[1].Phases[0].ActivePower)
+		be.Equal(t, 230.1, updates[1].Phases[0].Voltage) // retained from the status
+		be.Equal(t, 5.0, updates[2].TotalPower())
+	})
+	t.Run(`authentication`, func(t *testing.T) {
+		addr := streamDevice(t, "secret", 0)
+		updates, err := collect(Gen2Stream{Addr: addr, Password: "secret"}, 1)
+		be.NilErr(t, err)
+		be.Equal(t, 30.0, updates[0].TotalPower())
+	})
+	t.Run(`new challenges`, func(t *testing.T) {
+		addr := streamDevice(t, "secret", 2)
+		updates, err := collect(Gen2Stream{Addr: addr, Password: "secret"}, 1)
+		be.NilErr(t, err)
+		be.Equal(t, 30.0, updates[0].TotalPower())
+	})
+	t.Run(`wrong password`, func(t *testing.T) {
+		addr := streamDevice(t, "secret", 0)
+		_, err := collect(Gen2Stream{Addr: addr, Password: "wrong"}, 1)
+		be.Nonzero(t, err)
+	})
+	t.Run(`connection closed`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, err := websocket.Upgrade(w, r)
+			if err == nil {
+				_ = c.Close()
+			}
+		}))
+		defer server.Close()
+		u, _ := url.Parse(server.URL)
+		_, err := collect(Gen2Stream{Addr: u.Host}, 1)
+		be.Nonzero(t, err)
+	})
+}
@@ -0,0 +1,76 @@
+package shelly
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ClientOptions configure the http.Client returned by NewClient.
+type ClientOptions struct {
+	// Password of Gen2 devices, empty if the device is not password protected.
+	Password string
+	// Timeout of a request including reading the response, defaults to 2 seconds.
+	Timeout time.Duration
+	// IdleTimeout is the time an idle connection is kept open for reuse, defaults to 30 seconds.
+	// A negative value disables keep-alive.
+	IdleTimeout time.Duration
+}
+
+// NewClient returns a http.Client for polling a single device. The connection is reused between requests,
+// with a password the client authenticates on Gen2 devices.
+func NewClient(opts ClientOptions) *http.Client {
+	if opts.Timeout == 0 {
+		opts.Timeout = 2 * time.Second
+	}
+	if opts.IdleTimeout == 0 {
+		opts.IdleTimeout = 30 * time.Second
+	}
+	var transport http.RoundTripper = &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: opts.Timeout, KeepAlive: 30 * time.Second}).DialContext,
+		MaxIdleConnsPerHost:   1,
+		IdleConnTimeout:       max(opts.IdleTimeout, 0),
+		DisableKeepAlives:     opts.IdleTimeout < 0,
+		ResponseHeaderTimeout: opts.Timeout,
+		// the responses are small, the devices do not compress.
+		DisableCompression: true,
+	}
+	if opts.Password != "" {
+		transport = &DigestTransport{Username: "admin", Password: opts.Password, Transport: transport}
+	}
+	return &http.Client{Transport: transport, Timeout: opts.Timeout}
+}
+
+// getJSON requests url from the shelly device and decodes the JSON response into v.
+func getJSON(ctx context.Context, client *http.Client, url url.URL, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to construct request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to read from shelly device: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code from shelly device: %v", resp.StatusCode)
+	}
+
+	// we expect no valid response larger than 1mb
+	bodyReader := io.LimitReader(resp.Body, 1024*1024)
+
+	d := json.NewDecoder(bodyReader)
+	err = d.Decode(v)
+	if err != nil {
+		return fmt.Errorf("failed to parse response from shelly device: %w", err)
+	}
+
+	return nil
+}
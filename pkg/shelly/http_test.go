@@ -0,0 +1,63 @@
+package shelly
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestNewClient(t *testing.T) {
+	var connections atomic.Int32
+	hang := make(chan struct{})
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("id") == "1" {
+			select {
+			case <-hang:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"id":0,"total_act_power":100}`)
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+	defer close(hang)
+	u, _ := url.Parse(server.URL)
+
+	t.Run(`connection reuse`, func(t *testing.T) {
+		m := Gen2Meter{Addr: u.Host, Client: NewClient(ClientOptions{})}
+		for range 3 {
+			_, err := m.Read(context.Background())
+			be.NilErr(t, err)
+		}
+		be.Equal(t, int32(1), connections.Load())
+	})
+	t.Run(`timeout`, func(t *testing.T) {
+		m := Gen2Meter{Addr: u.Host, Client: NewClient(ClientOptions{Timeout: 50 * time.Millisecond}), ID: 1}
+		start := time.Now()
+		_, err := m.Read(context.Background())
+		be.Nonzero(t, err)
+		be.True(t, time.Since(start) < time.Second)
+	})
+	t.Run(`context`, func(t *testing.T) {
+		m := Gen2Meter{Addr: u.Host, Client: NewClient(ClientOptions{Timeout: time.Minute}), ID: 1}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := m.Read(ctx)
+		be.Nonzero(t, err)
+		be.True(t, ctx.Err() != nil)
+	})
+}
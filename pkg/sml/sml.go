@@ -0,0 +1,311 @@
+// Package sml reads the Smart Message Language (SML) files sent by german utility meters on their
+// optical interface. Only the transport layer and the values of GetListResponse messages are decoded.
+package sml
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// OBIS identifies a value of the meter, e.g. 1-0:16.7.0*255.
+type OBIS [6]byte
+
+func (o OBIS) String() string {
+	return fmt.Sprintf("%d-%d:%d.%d.%d*%d", o[0], o[1], o[2], o[3], o[4], o[5])
+}
+
+var (
+	// OBISImportEnergy is the total energy taken from the grid (1.8.0).
+	OBISImportEnergy = OBIS{1, 0, 1, 8, 0, 255}
+	// OBISExportEnergy is the total energy fed into the grid (2.8.0).
+	OBISExportEnergy = OBIS{1, 0, 2, 8, 0, 255}
+	// OBISPower is the current active power (16.7.0), positive values are import from the grid.
+	OBISPower = OBIS{1, 0, 16, 7, 0, 255}
+)
+
+// Unit is the DLMS unit code of a value.
+type Unit byte
+
+const (
+	UnitWatt     Unit = 27
+	UnitWattHour Unit = 30
+)
+
+// Reading is a numeric value of the meter.
+type Reading struct {
+	OBIS OBIS
+	Unit Unit
+	// Value with the scaler applied.
+	Value float64
+}
+
+// ErrChecksum is returned by ReadFile if the CRC of the file does not match.
+var ErrChecksum = errors.New("sml: checksum mismatch")
+
+var (
+	escape = []byte{0x1b, 0x1b, 0x1b, 0x1b}
+	start  = []byte{0x1b, 0x1b, 0x1b, 0x1b, 0x01, 0x01, 0x01, 0x01}
+)
+
+// maxFileSize limits the size of a file to recover from a lost end sequence.
+const maxFileSize = 8192
+
+// Reader reads SML files from a stream.
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// ReadFile returns the messages of the next file without escape sequences and padding. Data before
+// the start sequence is skipped. ErrChecksum is returned for corrupted files, reading can continue
+// with the next file.
+func (r *Reader) ReadFile() ([]byte, error) {
+	err := r.seekStart()
+	if err != nil {
+		return nil, err
+	}
+
+	raw := append([]byte{}, start...) // input of the CRC
+	var messages []byte
+	block := make([]byte, 4)
+	for len(raw) < maxFileSize {
+		_, err = io.ReadFull(r.r, block)
+		if err != nil {
+			return nil, err
+		}
+		raw = append(raw, block...)
+		if !bytes.Equal(block, escape) {
+			messages = append(messages, block...)
+			continue
+		}
+
+		_, err = io.ReadFull(r.r, block)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case bytes.Equal(block, escape): // escaped escape sequence in the data
+			raw = append(raw, block...)
+			messages = append(messages, block...)
+		case block[0] == 0x1a: // end: 1a, number of padding bytes, crc
+			raw = append(raw, block[:2]...)
+			if crc16(raw) != binary.LittleEndian.Uint16(block[2:]) {
+				return nil, ErrChecksum
+			}
+			padding := int(block[1])
+			if padding > 3 || padding > len(messages) {
+				return nil, fmt.Errorf("sml: invalid padding %d", padding)
+			}
+			return messages[:len(messages)-padding], nil
+		case bytes.Equal(block, start[4:]): // start of a new file, the current file is incomplete
+			raw = append(raw[:0], start...)
+			messages = messages[:0]
+		default:
+			return nil, fmt.Errorf("sml: unknown escape sequence % x", block)
+		}
+	}
+	return nil, errors.New("sml: file too large")
+}
+
+// seekStart consumes the input including the next start sequence.
+func (r *Reader) seekStart() error {
+	matched := 0
+	for matched < len(start) {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case b == start[matched]:
+			matched++
+		case b == 0x1b && matched == 4:
+			// more than four escape bytes, the last four can still be the start of the sequence.
+		case b == 0x1b:
+			matched = 1
+		default:
+			matched = 0
+		}
+	}
+	return nil
+}
+
+// Parse returns the readings of all GetListResponse messages in messages as returned by ReadFile.
+// Values that are not numeric are skipped.
+func Parse(messages []byte) ([]Reading, error) {
+	d := decoder{data: messages}
+	var readings []Reading
+	for d.pos < len(d.data) {
+		v, err := d.next()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := v.(endOfMessage); ok {
+			continue
+		}
+		message, ok := v.([]any)
+		if !ok || len(message) != 6 {
+			return nil, errors.New("sml: invalid message")
+		}
+		body, ok := message[3].([]any)
+		if !ok || len(body) != 2 {
+			return nil, errors.New("sml: invalid message body")
+		}
+		if tag, _ := body[0].(uint64); tag != getListResponse {
+			continue
+		}
+		list, ok := body[1].([]any)
+		if !ok || len(list) != 7 {
+			return nil, errors.New("sml: invalid GetListResponse")
+		}
+		entries, ok := list[4].([]any)
+		if !ok {
+			return nil, errors.New("sml: invalid value list")
+		}
+		for _, e := range entries {
+			reading, ok := parseEntry(e)
+			if ok {
+				readings = append(readings, reading)
+			}
+		}
+	}
+	return readings, nil
+}
+
+const getListResponse = 0x0701
+
+// parseEntry converts a SML_ListEntry to a reading. It returns false if the value is not numeric.
+func parseEntry(v any) (Reading, bool) {
+	entry, ok := v.([]any)
+	if !ok || len(entry) != 7 {
+		return Reading{}, false
+	}
+	objName, ok := entry[0].([]byte)
+	if !ok || len(objName) != 6 {
+		return Reading{}, false
+	}
+	var value float64
+	switch v := entry[5].(type) {
+	case int64:
+		value = float64(v)
+	case uint64:
+		value = float64(v)
+	default:
+		return Reading{}, false
+	}
+	unit, _ := entry[3].(uint64)
+	scaler, _ := entry[4].(int64)
+
+	r := Reading{Unit: Unit(unit), Value: value * math.Pow10(int(scaler))}
+	copy(r.OBIS[:], objName)
+	return r, true
+}
+
+// endOfMessage is the value of the end of message marker.
+type endOfMessage struct{}
+
+// decoder decodes SML type-length-value encoded data.
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+// next returns the next value: []any for lists, []byte for octet strings, bool, int64, uint64,
+// nil for absent optional values or endOfMessage.
+func (d *decoder) next() (any, error) {
+	if d.pos >= len(d.data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := d.data[d.pos]
+	if b == 0x00 {
+		d.pos++
+		return endOfMessage{}, nil
+	}
+	if b == 0x01 {
+		d.pos++
+		return nil, nil
+	}
+
+	typ := b >> 4 & 0x07
+	length := int(b & 0x0f)
+	tlSize := 1
+	for b&0x80 != 0 {
+		if d.pos+tlSize >= len(d.data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		b = d.data[d.pos+tlSize]
+		length = length<<4 | int(b&0x0f)
+		tlSize++
+	}
+	d.pos += tlSize
+
+	if typ == 0x07 { // list, length is the number of elements
+		list := make([]any, 0, length)
+		for range length {
+			v, err := d.next()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	}
+
+	// the length of other types includes the type-length field.
+	size := length - tlSize
+	if size < 0 || d.pos+size > len(d.data) {
+		return nil, fmt.Errorf("sml: invalid length %d at %d", length, d.pos)
+	}
+	payload := d.data[d.pos : d.pos+size]
+	d.pos += size
+
+	switch typ {
+	case 0x00:
+		return payload, nil
+	case 0x04:
+		return size > 0 && payload[0] != 0, nil
+	case 0x05:
+		if size == 0 || size > 8 {
+			return nil, fmt.Errorf("sml: invalid integer size %d", size)
+		}
+		v := int64(int8(payload[0])) // sign extension
+		for _, b := range payload[1:] {
+			v = v<<8 | int64(b)
+		}
+		return v, nil
+	case 0x06:
+		if size == 0 || size > 8 {
+			return nil, fmt.Errorf("sml: invalid integer size %d", size)
+		}
+		var v uint64
+		for _, b := range payload {
+			v = v<<8 | uint64(b)
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("sml: unknown type %#x", typ)
+	}
+}
+
+// crc16 is the CRC-16/X-25 of the transport layer, it is sent little-endian.
+func crc16(data []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for range 8 {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0x8408
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return ^crc
+}
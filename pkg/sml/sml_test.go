@@ -0,0 +1,103 @@
+package sml
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	be.NilErr(t, err)
+	return data
+}
+
+func TestCRC16(t *testing.T) {
+	be.Equal(t, uint16(0x906e), crc16([]byte("123456789")))
+}
+
+func TestReadFile(t *testing.T) {
+	for _, tc := range []struct {
+		fixture  string
+		readings []Reading
+	}{
+		{
+			fixture: "power_import.bin",
+			readings: []Reading{
+				{OBIS: OBISImportEnergy, Unit: UnitWattHour, Value: 12345678.9},
+				{OBIS: OBISExportEnergy, Unit: UnitWattHour, Value: 9876.5},
+				{OBIS: OBISPower, Unit: UnitWatt, Value: 325},
+			},
+		},
+		{
+			// the server id contains an escape sequence and needs a two byte type-length field.
+			fixture: "power_export_escaped.bin",
+			readings: []Reading{
+				{OBIS: OBISImportEnergy, Unit: UnitWattHour, Value: 12345678.9},
+				{OBIS: OBISExportEnergy, Unit: UnitWattHour, Value: 9876.5},
+				{OBIS: OBISPower, Unit: UnitWatt, Value: -123.4},
+			},
+		},
+		{
+			fixture:  "no_power.bin",
+			readings: []Reading{{OBIS: OBISImportEnergy, Unit: UnitWattHour, Value: 12345678.9}},
+		},
+	} {
+		t.Run(tc.fixture, func(t *testing.T) {
+			// garbage before the file, e.g. when starting in the middle of a transmission.
+			input := append([]byte{0x00, 0x1b, 0x1b, 0x76, 0x1b, 0x1b, 0x1b, 0x1b, 0x1b}, readFixture(t, tc.fixture)...)
+			r := NewReader(bytes.NewReader(input))
+
+			messages, err := r.ReadFile()
+			be.NilErr(t, err)
+			readings, err := Parse(messages)
+			be.NilErr(t, err)
+			be.Equal(t, len(tc.readings), len(readings))
+			for i, expected := range tc.readings {
+				be.Equal(t, expected.OBIS, readings[i].OBIS)
+				be.Equal(t, expected.Unit, readings[i].Unit)
+				be.True(t, readings[i].Value > expected.Value-0.001 && readings[i].Value < expected.Value+0.001)
+			}
+
+			_, err = r.ReadFile()
+			be.True(t, errors.Is(err, io.EOF))
+		})
+	}
+}
+
+func TestReadFile_stream(t *testing.T) {
+	corrupted := readFixture(t, "power_import.bin")
+	corrupted[20] ^= 0xff
+	input := bytes.Join([][]byte{
+		readFixture(t, "power_import.bin"),
+		corrupted,
+		readFixture(t, "power_export_escaped.bin"),
+	}, nil)
+	r := NewReader(bytes.NewReader(input))
+
+	_, err := r.ReadFile()
+	be.NilErr(t, err)
+	_, err = r.ReadFile()
+	be.True(t, errors.Is(err, ErrChecksum))
+	messages, err := r.ReadFile()
+	be.NilErr(t, err)
+	readings, err := Parse(messages)
+	be.NilErr(t, err)
+	be.Equal(t, OBISPower, readings[2].OBIS)
+}
+
+func TestParse_truncated(t *testing.T) {
+	messages, err := NewReader(bytes.NewReader(readFixture(t, "power_import.bin"))).ReadFile()
+	be.NilErr(t, err)
+	_, err = Parse(messages[:len(messages)/2])
+	be.Nonzero(t, err)
+}
+
+func TestOBIS_String(t *testing.T) {
+	be.Equal(t, "1-0:16.7.0*255", OBISPower.String())
+}
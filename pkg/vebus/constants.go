@@ -26,6 +26,9 @@ const (
 	RAMIDOutputPowerUnfiltered     = 19
 )
 
+// RAMIDAssistantFirst is the RAM ID of the first assistant RAM record.
+const RAMIDAssistantFirst = 128
+
 // The following block defines Assistent ID to identify to which
 // assistant RAM records belong to.
 const (
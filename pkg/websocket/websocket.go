@@ -0,0 +1,308 @@
+// Package websocket implements the subset of the WebSocket protocol (RFC 6455) needed for the RPC channel
+// of Shelly Gen2 devices: text messages with fragmentation, ping/pong and close. Extensions and
+// subprotocols are not supported.
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+
+	// maxMessageSize limits the size of received messages.
+	maxMessageSize = 1 << 20
+
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// ErrClosed is returned by ReadMessage after the peer closed the connection.
+var ErrClosed = errors.New("websocket closed")
+
+// Conn is a websocket connection. ReadMessage must not be called concurrently, WriteMessage can be
+// called concurrently with ReadMessage and itself.
+type Conn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	// client connections mask the frames they send.
+	client bool
+
+	writeLock sync.Mutex
+}
+
+// Dial opens a websocket connection to rawURL (ws://host/path). The context is only used for the
+// opening handshake.
+func Dial(ctx context.Context, rawURL string) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != "ws" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "80")
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	key := make([]byte, 16)
+	_, _ = rand.Read(key)
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Host:       u.Host,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-Websocket-Key":     {base64.StdEncoding.EncodeToString(key)},
+			"Sec-Websocket-Version": {"13"},
+		},
+	}
+	err = req.Write(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to send handshake: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to read handshake response: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		_ = conn.Close()
+		return nil, fmt.Errorf("handshake failed with status code %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Sec-Websocket-Accept") != acceptKey(base64.StdEncoding.EncodeToString(key)) {
+		_ = conn.Close()
+		return nil, errors.New("handshake failed, invalid Sec-WebSocket-Accept")
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return &Conn{conn: conn, r: r, client: true}, nil
+}
+
+// Upgrade performs the server side of the opening handshake and takes over the connection of w.
+// On failure an error response is written to w.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-Websocket-Key")
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Upgrade", "websocket") ||
+		!headerContains(r.Header, "Connection", "upgrade") || key == "" {
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return nil, errors.New("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-Websocket-Version") != "13" {
+		w.Header().Set("Sec-Websocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusBadRequest)
+		return nil, errors.New("unsupported websocket version")
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("failed to hijack connection: %w", err)
+	}
+	_, err = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n")
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to send handshake response: %w", err)
+	}
+	return &Conn{conn: conn, r: rw.Reader}, nil
+}
+
+// ReadMessage returns the payload of the next text or binary message. Ping frames are answered while
+// waiting. ErrClosed is returned if the peer closed the connection.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var message []byte
+	started := false
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case opPing:
+			err = c.writeFrame(true, opPong, payload)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			_ = c.writeFrame(true, opClose, nil)
+			return nil, ErrClosed
+		case opText, opBinary:
+			if started {
+				return nil, errors.New("protocol error: new message before end of fragmented message")
+			}
+			started = true
+		case opContinuation:
+			if !started {
+				return nil, errors.New("protocol error: continuation without message")
+			}
+		default:
+			return nil, fmt.Errorf("protocol error: unknown opcode %#x", opcode)
+		}
+
+		if len(message)+len(payload) > maxMessageSize {
+			return nil, errors.New("message too large")
+		}
+		message = append(message, payload...)
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// WriteMessage sends data as a single text message.
+func (c *Conn) WriteMessage(data []byte) error {
+	return c.writeFrame(true, opText, data)
+}
+
+// Close sends a close frame and closes the underlying connection without waiting for the peer.
+func (c *Conn) Close() error {
+	_ = c.writeFrame(true, opClose, nil)
+	return c.conn.Close()
+}
+
+func (c *Conn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	_, err = io.ReadFull(c.r, header[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.r, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.r, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if length > maxMessageSize {
+		return false, 0, nil, errors.New("frame too large")
+	}
+
+	var mask [4]byte
+	if masked {
+		_, err = io.ReadFull(c.r, mask[:])
+		if err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, length)
+	_, err = io.ReadFull(c.r, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+func (c *Conn) writeFrame(fin bool, opcode byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+14)
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	var b1 byte
+	if c.client {
+		b1 = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, b0, b1|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, b0, b1|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, b0, b1|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	if c.client {
+		var mask [4]byte
+		_, _ = rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerContains checks if the comma separated header values of key contain token.
+func headerContains(header http.Header, key, token string) bool {
+	for _, value := range header.Values(key) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
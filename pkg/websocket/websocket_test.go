@@ -0,0 +1,89 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+// startServer runs handler for every websocket connection and returns the ws:// URL of the server.
+func startServer(t *testing.T, handler func(c *Conn)) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		handler(c)
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestConn(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run(`echo`, func(t *testing.T) {
+		url := startServer(t, func(c *Conn) {
+			for {
+				m, err := c.ReadMessage()
+				if err != nil {
+					return
+				}
+				_ = c.WriteMessage(m)
+			}
+		})
+		c, err := Dial(ctx, url)
+		be.NilErr(t, err)
+		defer c.Close()
+
+		for _, size := range []int{0, 5, 125, 126, 200, 70000} {
+			message := bytes.Repeat([]byte{'x'}, size)
+			be.NilErr(t, c.WriteMessage(message))
+			reply, err := c.ReadMessage()
+			be.NilErr(t, err)
+			be.Equal(t, size, len(reply))
+		}
+	})
+	t.Run(`fragmented message and ping`, func(t *testing.T) {
+		url := startServer(t, func(c *Conn) {
+			_ = c.writeFrame(false, opText, []byte(`{"a":`))
+			_ = c.writeFrame(true, opPing, []byte("ping"))
+			_ = c.writeFrame(true, opContinuation, []byte(`1}`))
+			_, _, pong, _ := c.readFrame()
+			_ = c.WriteMessage(pong)
+		})
+		c, err := Dial(ctx, url)
+		be.NilErr(t, err)
+		defer c.Close()
+
+		m, err := c.ReadMessage()
+		be.NilErr(t, err)
+		be.Equal(t, `{"a":1}`, string(m))
+		m, err = c.ReadMessage()
+		be.NilErr(t, err)
+		be.Equal(t, "ping", string(m))
+	})
+	t.Run(`close by peer`, func(t *testing.T) {
+		url := startServer(t, func(*Conn) {})
+		c, err := Dial(ctx, url)
+		be.NilErr(t, err)
+		defer c.Close()
+
+		_, err = c.ReadMessage()
+		be.True(t, errors.Is(err, ErrClosed))
+	})
+	t.Run(`no websocket server`, func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		defer server.Close()
+		_, err := Dial(ctx, "ws"+strings.TrimPrefix(server.URL, "http"))
+		be.Nonzero(t, err)
+	})
+}